@@ -0,0 +1,3 @@
+module github.com/city-demo/GGS_portal_go
+
+go 1.22
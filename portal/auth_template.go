@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// 认证请求方式
+const (
+	AuthMethodGet      = "GET"
+	AuthMethodPostForm = "POST_FORM"
+	AuthMethodPostJSON = "POST_JSON"
+)
+
+// AuthTemplate 认证请求模板
+type AuthTemplate struct {
+	Method  string
+	URL     *template.Template
+	Headers []templateField
+	Params  []templateField
+}
+
+// 模板中的一个键值对，值为模板
+type templateField struct {
+	Name  string
+	Value *template.Template
+}
+
+// 认证模板可引用的变量
+type authTemplateData struct {
+	UserID     string
+	Passwd     string
+	WlanUserIP string
+	WlanAcName string
+	MAC        string
+	Vlan       string
+	Rand       string // 每次请求重新生成的随机数
+	FormAction string // 从门户页面发现的表单提交地址
+
+	query url.Values
+	form  *htmlForm
+}
+
+// Query 获取重定向URL中的任意参数
+func (d *authTemplateData) Query(name string) string {
+	return d.query.Get(name)
+}
+
+// Form 获取从门户页面发现的表单字段
+func (d *authTemplateData) Form(name string) string {
+	if d.form == nil {
+		return ""
+	}
+	return d.form.value(name)
+}
+
+// 默认认证模板，与 GGS quickauth.do 接口一致
+var defaultAuthParams = [][2]string{
+	{"userid", "{{.UserID}}"},
+	{"passwd", "{{.Passwd}}"},
+	{"wlanacname", "{{.WlanAcName}}"},
+	{"portalpageid", `{{or (.Form "portalpageid") "2"}}`},
+	{"mac", "{{.MAC}}"},
+	{"wlanuserip", "{{.WlanUserIP}}"},
+}
+
+// 创建默认认证模板
+func newAuthTemplate() *AuthTemplate {
+	t := &AuthTemplate{
+		Method: AuthMethodGet,
+		URL:    mustParseTemplate("authURL", AuthEndpoint),
+	}
+	for _, p := range defaultAuthParams {
+		t.Params = append(t.Params, templateField{Name: p[0], Value: mustParseTemplate(p[0], p[1])})
+	}
+	return t
+}
+
+// 解析内置模板，失败说明代码有误
+func mustParseTemplate(name, text string) *template.Template {
+	t, err := parseTemplate(name, text)
+	if err != nil {
+		panic(fmt.Sprintf("内置模板 %s 无效: %v", name, err))
+	}
+	return t
+}
+
+// 解析模板，引用不存在的变量时报错
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+// 解析认证请求方式
+func parseAuthMethod(value string) (string, error) {
+	switch strings.ToUpper(value) {
+	case AuthMethodGet:
+		return AuthMethodGet, nil
+	case AuthMethodPostForm, "POST":
+		return AuthMethodPostForm, nil
+	case AuthMethodPostJSON:
+		return AuthMethodPostJSON, nil
+	}
+	return "", fmt.Errorf("未知的认证请求方式: %s", value)
+}
+
+// 解析 "名称<sep>模板" 形式的配置值
+func parseTemplateField(value, sep string) (templateField, error) {
+	idx := strings.Index(value, sep)
+	if idx <= 0 {
+		return templateField{}, fmt.Errorf("格式应为 名称%s值", sep)
+	}
+	name := strings.TrimSpace(value[:idx])
+	tmpl, err := parseTemplate(name, strings.TrimSpace(value[idx+len(sep):]))
+	if err != nil {
+		return templateField{}, fmt.Errorf("模板无效: %v", err)
+	}
+	return templateField{Name: name, Value: tmpl}, nil
+}
+
+// 渲染模板
+func renderTemplate(t *template.Template, data *authTemplateData) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("渲染模板 %s 失败: %v", t.Name(), err)
+	}
+	return buf.String(), nil
+}
+
+// 按模板构造认证请求，同时返回隐藏密码后的请求描述用于日志
+func (t *AuthTemplate) build(ctx context.Context, config *Config, params *AuthParams) (*http.Request, string, error) {
+	data := &authTemplateData{
+		UserID:     config.UserID,
+		Passwd:     config.Passwd,
+		WlanUserIP: params.WlanUserIP,
+		WlanAcName: params.WlanAcName,
+		MAC:        params.MAC,
+		Vlan:       params.Vlan,
+		Rand:       strconv.FormatInt(rand.Int63(), 10),
+		query:      params.Query,
+		form:       params.Form,
+	}
+	if params.Form != nil {
+		data.FormAction = params.Form.Action
+	}
+
+	method, authURL, body, err := t.render(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var reader io.Reader
+	if method == http.MethodPost {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, authURL, reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("创建认证请求失败: %v", err)
+	}
+	switch t.Method {
+	case AuthMethodPostForm:
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	case AuthMethodPostJSON:
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, h := range t.Headers {
+		value, err := renderTemplate(h.Value, data)
+		if err != nil {
+			return nil, "", err
+		}
+		req.Header.Set(h.Name, value)
+	}
+
+	// 用隐藏密码的数据重新渲染一次作为日志描述
+	masked := *data
+	masked.Passwd = "******"
+	_, descURL, descBody, err := t.render(&masked)
+	if err != nil {
+		return nil, "", err
+	}
+	desc := method + " " + descURL
+	if descBody != "" {
+		desc += " " + descBody
+	}
+	desc = strings.ReplaceAll(desc, url.QueryEscape(masked.Passwd), masked.Passwd)
+	return req, desc, nil
+}
+
+// 渲染请求方法、URL 与请求体
+func (t *AuthTemplate) render(data *authTemplateData) (string, string, string, error) {
+	authURL, err := renderTemplate(t.URL, data)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	// 参数按配置顺序拼接，部分控制器对顺序敏感
+	var query []string
+	fields := make(map[string]string, len(t.Params))
+	for _, p := range t.Params {
+		value, err := renderTemplate(p.Value, data)
+		if err != nil {
+			return "", "", "", err
+		}
+		query = append(query, url.QueryEscape(p.Name)+"="+url.QueryEscape(value))
+		fields[p.Name] = value
+	}
+
+	// 附加模板中没有的表单隐藏字段
+	if data.form != nil {
+		for _, in := range data.form.hidden() {
+			if _, ok := fields[in.Name]; ok {
+				continue
+			}
+			query = append(query, url.QueryEscape(in.Name)+"="+url.QueryEscape(in.Value))
+			fields[in.Name] = in.Value
+		}
+	}
+	encoded := strings.Join(query, "&")
+
+	switch t.Method {
+	case AuthMethodGet:
+		if encoded == "" {
+			return http.MethodGet, authURL, "", nil
+		}
+		if strings.Contains(authURL, "?") {
+			return http.MethodGet, authURL + "&" + encoded, "", nil
+		}
+		return http.MethodGet, authURL + "?" + encoded, "", nil
+	case AuthMethodPostForm:
+		return http.MethodPost, authURL, encoded, nil
+	case AuthMethodPostJSON:
+		payload, err := json.Marshal(fields)
+		if err != nil {
+			return "", "", "", fmt.Errorf("编码JSON失败: %v", err)
+		}
+		return http.MethodPost, authURL, string(payload), nil
+	}
+	return "", "", "", errors.New("未知的认证请求方式: " + t.Method)
+}
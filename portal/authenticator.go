@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// 门户类型，对应配置项 portalType
+const (
+	PortalGGS    = "ggs"
+	PortalRuijie = "ruijie"
+	PortalSrun   = "srun"
+	PortalForm   = "form"
+	PortalWISPr  = "wispr"
+)
+
+// Authenticator 门户认证后端
+type Authenticator interface {
+	// Detect 检测网络状态，需要认证时返回 "NEED_AUTH" 与认证参数，已认证时返回登出链接（可为空）
+	Detect(ctx context.Context, config *Config) (string, *AuthParams, error)
+	// Login 提交认证，成功时返回登出链接（可为空）
+	Login(ctx context.Context, config *Config, params *AuthParams) (string, error)
+	// Verify 认证后确认是否已联网
+	Verify(ctx context.Context, config *Config) (bool, error)
+	// Logout 访问登出链接下线
+	Logout(ctx context.Context, logout string) error
+	// Describe 后端名称，用于日志
+	Describe() string
+}
+
+// ownCredentials 使用专用账号（wisprUserid、formUserid）而非校园网账号认证的后端实现该接口，
+// 校园网门户的身份校验（trustAcName、trustHost、trustGatewayMAC）只约束发送校园网账号的后端
+type ownCredentials interface {
+	ownCredentials()
+}
+
+// 认证后端是否发送校园网账号
+func sendsCampusCredentials(auth Authenticator) bool {
+	_, own := auth.(ownCredentials)
+	return !own
+}
+
+// 按门户类型注册的认证后端
+var authenticators = map[string]Authenticator{
+	PortalGGS:    ggsAuthenticator{},
+	PortalRuijie: ruijieAuthenticator{},
+	PortalSrun:   srunAuthenticator{},
+	PortalForm:   formAuthenticator{},
+	PortalWISPr:  wisprAuthenticator{},
+}
+
+// 获取配置的门户类型对应的认证后端
+func portalAuthenticator(config *Config) Authenticator {
+	if auth, ok := authenticators[config.PortalType]; ok {
+		return auth
+	}
+	return authenticators[PortalGGS]
+}
+
+// 获取检测结果对应的认证后端：检测识别出门户类型（如 WISPr 热点）时使用该类型，否则使用配置的类型
+func detectedAuthenticator(config *Config, params *AuthParams) Authenticator {
+	if auth, ok := authenticators[params.PortalType]; ok {
+		return auth
+	}
+	return portalAuthenticator(config)
+}
+
+// 已注册的门户类型，用于日志
+func portalTypes() []string {
+	types := make([]string, 0, len(authenticators))
+	for name := range authenticators {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// probeAuthenticator 基于 HTTP 探测的检测、验证与登出，各后端共用
+type probeAuthenticator struct{}
+
+func (probeAuthenticator) Detect(ctx context.Context, config *Config) (string, *AuthParams, error) {
+	return checkNetworkStatus(ctx, config)
+}
+
+func (probeAuthenticator) Verify(ctx context.Context, config *Config) (bool, error) {
+	return verifyAuth(ctx, config)
+}
+
+func (probeAuthenticator) Logout(ctx context.Context, logout string) error {
+	return doLogout(ctx, logout)
+}
+
+// ggsAuthenticator GGS quickauth.do 认证，请求由认证模板构造
+type ggsAuthenticator struct{ probeAuthenticator }
+
+func (ggsAuthenticator) Login(ctx context.Context, config *Config, params *AuthParams) (string, error) {
+	if config.DiscoverForm && params.Form == nil {
+		form, err := discoverPortalForm(ctx, httpClient(ctx, true), params.RedirectURL)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			logCtx(ctx, WARN, "发现门户表单失败: %v，使用模板中的默认值", err)
+		} else {
+			params.Form = form
+		}
+	}
+	return "", doAuth(ctx, config, params)
+}
+
+func (ggsAuthenticator) Describe() string { return "GGS quickauth.do" }
+
+// ruijieAuthenticator 锐捷 ePortal 认证
+type ruijieAuthenticator struct{ probeAuthenticator }
+
+func (ruijieAuthenticator) Login(ctx context.Context, config *Config, params *AuthParams) (string, error) {
+	return ruijieLogin(ctx, config, params)
+}
+
+func (a ruijieAuthenticator) Logout(ctx context.Context, logout string) error {
+	// 探测规则得到的登出链接不是 InterFace.do 接口时直接访问
+	if !isRuijieLogout(logout) {
+		return a.probeAuthenticator.Logout(ctx, logout)
+	}
+	return ruijieLogout(ctx, logout)
+}
+
+func (ruijieAuthenticator) Describe() string { return "锐捷 ePortal" }
+
+// srunAuthenticator 深澜 SRun 认证
+type srunAuthenticator struct{ probeAuthenticator }
+
+func (srunAuthenticator) Login(ctx context.Context, config *Config, params *AuthParams) (string, error) {
+	return srunLogin(ctx, config, params)
+}
+
+func (srunAuthenticator) Describe() string { return "深澜 SRun" }
+
+// wisprAuthenticator WISPr 1.0 智能客户端认证，探测到 WISPr 消息时由检测结果选用
+type wisprAuthenticator struct{ probeAuthenticator }
+
+func (wisprAuthenticator) Login(ctx context.Context, config *Config, params *AuthParams) (string, error) {
+	if params.WISPr == nil {
+		return "", errors.New("探测响应中没有WISPr消息")
+	}
+	return wisprLogin(ctx, config, params.WISPr, params.RedirectURL)
+}
+
+func (wisprAuthenticator) Describe() string { return "WISPr 1.0" }
+
+func (wisprAuthenticator) ownCredentials() {}
@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestSendsCampusCredentials(t *testing.T) {
+	want := map[string]bool{
+		PortalGGS:    true,
+		PortalRuijie: true,
+		PortalSrun:   true,
+		PortalForm:   false,
+		PortalWISPr:  false,
+	}
+	for name, auth := range authenticators {
+		if got := sendsCampusCredentials(auth); got != want[name] {
+			t.Errorf("sendsCampusCredentials(%s) = %v, want %v", name, got, want[name])
+		}
+	}
+}
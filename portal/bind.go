@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// 按配置创建拨号器，配置了网卡或源地址时所有连接都从该网卡发出；
+// 绑定失败时返回错误，不能改用默认路由，否则可能从错误的网卡认证
+func newDialer(config *Config) (*net.Dialer, error) {
+	// 连接超时由当前阶段的超时决定
+	dialer := &net.Dialer{KeepAlive: 30 * time.Second}
+	if config.SourceIP != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: config.SourceIP}
+	}
+	if config.Interface != "" {
+		if err := bindInterface(dialer, config.Interface); err != nil {
+			return nil, fmt.Errorf("绑定网卡 %s 失败: %v", config.Interface, err)
+		}
+	}
+	return dialer, nil
+}
+
+// 按配置创建拨号函数，绑定网卡且未指定源地址时由各平台决定如何从该网卡发出
+func dialFunc(config *Config, dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if config.Interface == "" || dialer.LocalAddr != nil {
+		return dialer.DialContext
+	}
+	return interfaceDial(dialer, config.Interface)
+}
+
+// bindError 绑定网卡失败，通常是网卡地址或状态已变化，需要重建传输层
+type bindError struct{ err error }
+
+func (e *bindError) Error() string { return e.err.Error() }
+func (e *bindError) Unwrap() error { return e.err }
+
+// Windows 的 WSAEADDRNOTAVAIL，源地址已不属于本机
+const wsaEADDRNOTAVAIL = syscall.Errno(10049)
+
+// 判断拨号错误是否由绑定网卡或源地址失败引起
+func isBindError(err error) bool {
+	var bindErr *bindError
+	if errors.As(err, &bindErr) {
+		return true
+	}
+	return errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.ENODEV) || errors.Is(err, wsaEADDRNOTAVAIL)
+}
+
+// 未绑定网卡或源地址且未自定义解析时共用的传输层
+var directTransport = newSharedTransport(nil)
+
+// 按配置创建传输层，每个网卡一个，在多次认证流程间复用连接
+func newTransport(config *Config) (*sharedTransport, error) {
+	if config.Interface == "" && config.SourceIP == nil && !config.DNS.configured() {
+		return directTransport, nil
+	}
+	dialer, err := newDialer(config)
+	if err != nil {
+		return nil, err
+	}
+	dial := dialFunc(config, dialer)
+	if config.DNS.configured() {
+		dial = newDNSDialer(config, dialer).DialContext
+	}
+
+	// 绑定失败时标记传输层失效，由调用方重建
+	var transport *sharedTransport
+	transport = newSharedTransport(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil && isBindError(err) {
+			transport.stale.Store(true)
+		}
+		return conn, err
+	})
+	return transport, nil
+}
+
+// 启动时检查网卡与源地址是否存在
+func checkBinding(config *Config) error {
+	if config.Interface != "" {
+		iface, err := net.InterfaceByName(config.Interface)
+		if err != nil {
+			return fmt.Errorf("网卡 %s 不存在: %v", config.Interface, err)
+		}
+		if iface.Flags&net.FlagUp == 0 {
+			log(WARN, "网卡 %s 当前未启用", config.Interface)
+		}
+		if config.SourceIP != nil && !interfaceHasIP(iface, config.SourceIP) {
+			return fmt.Errorf("源地址 %s 不属于网卡 %s", config.SourceIP, config.Interface)
+		}
+		log(INFO, "所有请求将绑定到网卡 %s", config.Interface)
+	}
+	if config.SourceIP != nil && config.Interface == "" {
+		if _, err := interfaceByIP(config.SourceIP); err != nil {
+			return err
+		}
+		log(INFO, "所有请求将使用源地址 %s", config.SourceIP)
+	}
+	return nil
+}
+
+// 查找拥有指定地址的网卡
+func interfaceByIP(ip net.IP) (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("获取网卡列表失败: %v", err)
+	}
+	for i := range ifaces {
+		if interfaceHasIP(&ifaces[i], ip) {
+			return &ifaces[i], nil
+		}
+	}
+	return nil, fmt.Errorf("本机没有地址为 %s 的网卡", ip)
+}
+
+// 判断网卡是否拥有指定地址
+func interfaceHasIP(iface *net.Interface, ip net.IP) bool {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 获取网卡的首个地址，优先 IPv4
+func interfaceAddr(name string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return newLocalLink(iface).addr()
+}
+
+// 拨号时使用的解析器，与连接一样从指定网卡发出
+func boundResolver(dialer *net.Dialer) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		},
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// Linux 使用 SO_BINDTODEVICE 绑定网卡，不受路由表影响，需要 root 或 CAP_NET_RAW 权限
+func bindInterface(dialer *net.Dialer, name string) error {
+	if _, err := net.InterfaceByName(name); err != nil {
+		return err
+	}
+	dialer.Control = func(network, address string, c syscall.RawConn) error {
+		var bindErr error
+		if err := c.Control(func(fd uintptr) {
+			bindErr = syscall.BindToDevice(int(fd), name)
+		}); err != nil {
+			return err
+		}
+		return bindErr
+	}
+	// DNS 查询同样从该网卡发出
+	resolverDialer := *dialer
+	resolverDialer.LocalAddr = nil
+	dialer.Resolver = boundResolver(&resolverDialer)
+	return nil
+}
+
+// Linux 已在套接字上绑定网卡，直接拨号
+func interfaceDial(dialer *net.Dialer, name string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return dialer.DialContext
+}
@@ -0,0 +1,75 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// 其他平台没有 SO_BINDTODEVICE，改为以网卡地址作为源地址，拨号时再取地址
+func bindInterface(dialer *net.Dialer, name string) error {
+	_, err := net.InterfaceByName(name)
+	return err
+}
+
+// 每次拨号时按地址族取网卡当前的地址作为源地址，DHCP 续租或漫游后地址变化也能使用新地址
+func interfaceDial(dialer *net.Dialer, name string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		ip, err := sourceAddr(name, network, addr)
+		if err != nil {
+			return nil, &bindError{err: fmt.Errorf("获取网卡 %s 的地址失败: %v", name, err)}
+		}
+		d := *dialer
+		switch network {
+		case "udp", "udp4", "udp6":
+			d.LocalAddr = &net.UDPAddr{IP: ip}
+		default:
+			d.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+}
+
+// 按拨号的网络与目标地址选择网卡地址：tcp4/tcp6 与 IP 目标取对应地址族，域名目标优先 IPv4
+func sourceAddr(name, network, addr string) (net.IP, error) {
+	var ipv6 bool
+	switch network[len(network)-1] {
+	case '4':
+	case '6':
+		ipv6 = true
+	default:
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return interfaceAddr(name)
+		}
+		ipv6 = ip.To4() == nil
+	}
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if (ipNet.IP.To4() == nil) == ipv6 {
+			return ipNet.IP, nil
+		}
+	}
+	if ipv6 {
+		return nil, fmt.Errorf("网卡没有可用的 IPv6 地址")
+	}
+	return nil, fmt.Errorf("网卡没有可用的 IPv4 地址")
+}
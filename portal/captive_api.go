@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// captiveAPI 配置的特殊取值
+const (
+	CaptiveAPIAuto = "auto" // 从本机 DHCP 租约中读取
+	CaptiveAPIOff  = "off"  // 不使用
+)
+
+// 租约文件读取上限
+const maxLeaseSize = 1 << 20
+
+// 可能包含 DHCP 选项 114 的租约文件
+var dhcpLeaseGlobs = []string{
+	"/var/lib/dhcp/*.leases",          // dhclient (Debian/Ubuntu)
+	"/var/lib/dhclient/*.lease*",      // dhclient (RHEL/Fedora)
+	"/var/lib/NetworkManager/*.lease", // NetworkManager（dhclient 及内置客户端）
+	"/run/systemd/netif/leases/*",     // systemd-networkd
+}
+
+var (
+	// dhclient: option captive-portal "..."; / option default-url "..."; / option unknown-114 ...;
+	dhclientOptionPattern = regexp.MustCompile(`option\s+(?:captive-portal|default-url|unknown-114)\s+([^;]+);`)
+	// systemd-networkd / NetworkManager: CAPTIVE_PORTAL=...
+	keyValueOptionPattern = regexp.MustCompile(`(?m)^(?:CAPTIVE_PORTAL|OPTION_114)=(.+)$`)
+)
+
+// CaptiveStatus RFC 8908 Captive Portal API 的响应
+type CaptiveStatus struct {
+	Captive          bool   `json:"captive"`
+	UserPortalURL    string `json:"user-portal-url"`
+	VenueInfoURL     string `json:"venue-info-url"`
+	CanExtendSession bool   `json:"can-extend-session"`
+	SecondsRemaining *int64 `json:"seconds-remaining"`
+	BytesRemaining   *int64 `json:"bytes-remaining"`
+}
+
+// 获取 Captive Portal API 地址，未配置或未发现时返回空
+func captiveAPIURI(ctx context.Context, config *Config) string {
+	switch config.CaptiveAPI {
+	case CaptiveAPIOff:
+		return ""
+	case CaptiveAPIAuto:
+		uri, source := findLeaseCaptiveAPI()
+		if uri != "" {
+			logCtx(ctx, DEBUG, "从DHCP租约 %s 中发现 Captive Portal API: %s", source, uri)
+		}
+		return uri
+	default:
+		return config.CaptiveAPI
+	}
+}
+
+// 在本机 DHCP 租约文件中查找选项 114 (RFC 8910)，取最近修改的文件中最后一次出现的值
+func findLeaseCaptiveAPI() (string, string) {
+	var uri, source string
+	var newest time.Time
+	for _, pattern := range dhcpLeaseGlobs {
+		files, _ := filepath.Glob(pattern)
+		for _, file := range files {
+			info, err := os.Stat(file)
+			if err != nil || info.IsDir() || info.ModTime().Before(newest) {
+				continue
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				continue
+			}
+			found := parseLeaseCaptiveAPI(f)
+			f.Close()
+			if found != "" {
+				uri, source, newest = found, file, info.ModTime()
+			}
+		}
+	}
+	return uri, source
+}
+
+// 从租约文件内容中解析选项 114
+func parseLeaseCaptiveAPI(r io.Reader) string {
+	raw, err := io.ReadAll(io.LimitReader(r, maxLeaseSize))
+	if err != nil {
+		return ""
+	}
+	content := string(raw)
+
+	var value string
+	if matches := dhclientOptionPattern.FindAllStringSubmatch(content, -1); len(matches) > 0 {
+		value = matches[len(matches)-1][1]
+	} else if matches := keyValueOptionPattern.FindAllStringSubmatch(content, -1); len(matches) > 0 {
+		value = matches[len(matches)-1][1]
+	}
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+
+	// dhclient 对未知选项输出冒号分隔的十六进制
+	if strings.Count(value, ":") > 2 && !strings.Contains(value, "://") {
+		if raw, err := hex.DecodeString(strings.ReplaceAll(value, ":", "")); err == nil {
+			value = string(raw)
+		}
+	}
+
+	// RFC 8910: 取值 urn:ietf:params:capport:unrestricted 表示没有门户
+	if value == "" || strings.EqualFold(value, "urn:ietf:params:capport:unrestricted") {
+		return ""
+	}
+	return value
+}
+
+// 查询 Captive Portal API (RFC 8908)
+func queryCaptiveAPI(ctx context.Context, uri string) (*CaptiveStatus, error) {
+	if !strings.HasPrefix(strings.ToLower(uri), "https://") {
+		return nil, fmt.Errorf("Captive Portal API 必须使用 HTTPS: %s", uri)
+	}
+
+	logCtx(ctx, DEBUG, "查询 Captive Portal API: %s", uri)
+	client := httpClient(ctx, true)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Accept", "application/captive+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求失败: %v", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logCtx(ctx, ERROR, "关闭响应体失败: %v", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("状态码异常: %d", resp.StatusCode)
+	}
+	body, err := readBody(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %v", err)
+	}
+
+	status := &CaptiveStatus{}
+	if err := json.Unmarshal(body, status); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %v", err)
+	}
+	if status.Captive && status.UserPortalURL == "" {
+		return nil, errors.New("响应中 captive 为 true 但没有 user-portal-url")
+	}
+	return status, nil
+}
+
+// 通过 Captive Portal API 检测网络状态，无法判断时 ok 为 false，由调用方回退到 HTTP 探测
+func checkCaptiveAPI(ctx context.Context, config *Config) (result string, params *AuthParams, ok bool) {
+	uri := captiveAPIURI(ctx, config)
+	if uri == "" {
+		return "", nil, false
+	}
+
+	status, err := queryCaptiveAPI(ctx, uri)
+	if err != nil {
+		logCtx(ctx, WARN, "Captive Portal API 查询失败，回退到HTTP探测: %v", err)
+		return "", nil, false
+	}
+	if status.SecondsRemaining != nil {
+		remaining := time.Duration(*status.SecondsRemaining) * time.Second
+		if s := sessionFrom(ctx); s != nil {
+			s.remaining = remaining
+		}
+		logCtx(ctx, INFO, "Captive Portal API: captive=%v, 会话剩余 %v", status.Captive, remaining)
+	} else {
+		logCtx(ctx, INFO, "Captive Portal API: captive=%v", status.Captive)
+	}
+
+	if !status.Captive {
+		return "", nil, true
+	}
+
+	logCtx(ctx, INFO, "Captive Portal API 指示需要认证，门户地址: %s", status.UserPortalURL)
+	params, err = parsePortalParams(ctx, config, status.UserPortalURL)
+	if err != nil {
+		logCtx(ctx, WARN, "无法从门户地址解析认证参数，回退到HTTP探测: %v", err)
+		return "", nil, false
+	}
+	return "NEED_AUTH", params, true
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLeaseCaptiveAPI(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name: "dhclient 已知选项，取最后一次租约",
+			content: `lease {
+  interface "wlan0";
+  fixed-address 10.20.3.4;
+  option subnet-mask 255.255.0.0;
+  option routers 10.20.0.1;
+  option captive-portal "https://portal.example.edu.cn/api/old";
+  renew 2 2026/10/13 02:11:42;
+}
+lease {
+  interface "wlan0";
+  fixed-address 10.20.3.4;
+  option subnet-mask 255.255.0.0;
+  option routers 10.20.0.1;
+  option captive-portal "https://portal.example.edu.cn/api/captive";
+  renew 2 2026/10/13 06:40:17;
+}
+`,
+			want: "https://portal.example.edu.cn/api/captive",
+		},
+		{
+			name: "dhclient 未知选项的十六进制",
+			content: `lease {
+  interface "eth0";
+  option unknown-114 68:74:74:70:73:3a:2f:2f:63:70:2e:65:78:61:6d:70:6c:65:2e:63:6f:6d:2f;
+}
+`,
+			want: "https://cp.example.com/",
+		},
+		{
+			name: "systemd-networkd",
+			content: `# This is private data. Do not parse.
+ADDRESS=10.20.3.4
+NETMASK=255.255.0.0
+ROUTER=10.20.0.1
+SERVER_ADDRESS=10.20.0.1
+CAPTIVE_PORTAL=https://portal.example.edu.cn/api/captive
+LIFETIME=7200
+`,
+			want: "https://portal.example.edu.cn/api/captive",
+		},
+		{
+			name:    "RFC 8910 无门户",
+			content: "CAPTIVE_PORTAL=urn:ietf:params:capport:unrestricted\n",
+			want:    "",
+		},
+		{
+			name:    "没有选项 114",
+			content: "ADDRESS=10.20.3.4\nROUTER=10.20.0.1\n",
+			want:    "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseLeaseCaptiveAPI(strings.NewReader(tt.content)); got != tt.want {
+				t.Errorf("parseLeaseCaptiveAPI() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 发现表单时最多跟随的页面脚本跳转次数
+const maxDiscoverHops = 2
+
+// 获取重定向指向的门户页面并解析其中的登录表单
+func discoverPortalForm(ctx context.Context, client *http.Client, pageURL string) (*htmlForm, error) {
+	logCtx(ctx, DEBUG, "开始从门户页面发现登录表单: %s", pageURL)
+
+	for hop := 0; hop <= maxDiscoverHops; hop++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("创建请求失败: %v", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("获取门户页面失败: %v", err)
+		}
+		body, err := readBody(resp.Body)
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logCtx(ctx, ERROR, "关闭响应体失败: %v", closeErr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取门户页面失败: %v", err)
+		}
+		logCtx(ctx, DEBUG, "门户页面 %s 状态码: %d, 长度: %d", resp.Request.URL, resp.StatusCode, len(body))
+
+		// 以最终地址为基准解析相对链接
+		base := resp.Request.URL
+		if form := pickLoginForm(parseForms(string(body), base)); form != nil {
+			logCtx(ctx, INFO, "从门户页面发现登录表单: action=%s, method=%s, 隐藏字段: %s",
+				form.Action, form.Method, describeInputs(form.hidden()))
+			return form, nil
+		}
+
+		// 页面可能只是一段跳转脚本，继续跟随
+		next := extractRedirectURL(ctx, string(body), base)
+		if next == "" {
+			break
+		}
+		logCtx(ctx, DEBUG, "门户页面中没有表单，跟随脚本跳转到: %s", next)
+		pageURL = next
+	}
+	return nil, errors.New("门户页面中未找到登录表单")
+}
+
+// 选出登录表单：优先含密码框的表单，其次含隐藏字段的表单
+func pickLoginForm(forms []*htmlForm) *htmlForm {
+	for _, f := range forms {
+		if f.hasPassword() {
+			return f
+		}
+	}
+	for _, f := range forms {
+		if len(f.hidden()) > 0 {
+			return f
+		}
+	}
+	return nil
+}
+
+// 输入项描述，用于日志
+func describeInputs(inputs []htmlInput) string {
+	if len(inputs) == 0 {
+		return "无"
+	}
+	parts := make([]string, 0, len(inputs))
+	for _, in := range inputs {
+		parts = append(parts, in.Name+"="+in.Value)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DNS 相关的默认值
+const (
+	DNSPort          = "53"
+	DNSLookupTimeout = 3 * time.Second
+)
+
+// 运营商级 NAT 地址段，公网域名不应解析到其中
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// DNSConfig 自定义域名解析，未配置时使用系统 DNS
+type DNSConfig struct {
+	Servers     []string            // DNS 服务器，host:port
+	Hosts       map[string][]net.IP // 静态解析，优先于 DNS
+	DoHURL      string              // DNS over HTTPS 地址，优先于 DNS 服务器
+	HijackCheck bool                // 是否检测门户劫持 DNS
+	HijackHosts []string            // 额外用于劫持检测的公网域名
+}
+
+// 是否需要自定义解析
+func (d *DNSConfig) configured() bool {
+	return len(d.Servers) > 0 || len(d.Hosts) > 0 || d.DoHURL != ""
+}
+
+// 解析 dnsServer 配置，未写端口时使用 53
+func (d *DNSConfig) addServer(value string) error {
+	if ip := net.ParseIP(strings.Trim(value, "[]")); ip != nil {
+		d.Servers = append(d.Servers, net.JoinHostPort(ip.String(), DNSPort))
+		return nil
+	}
+	host, _, err := net.SplitHostPort(value)
+	if err != nil || net.ParseIP(host) == nil {
+		return fmt.Errorf("无效的DNS服务器: %s", value)
+	}
+	d.Servers = append(d.Servers, value)
+	return nil
+}
+
+// 解析 dnsHost 配置，格式: 域名 IP [IP...]
+func (d *DNSConfig) addHost(value string) error {
+	fields := strings.Fields(value)
+	if len(fields) < 2 {
+		return fmt.Errorf("dnsHost 格式应为 \"域名 IP [IP...]\": %s", value)
+	}
+	host := strings.ToLower(strings.TrimSuffix(fields[0], "."))
+	for _, field := range fields[1:] {
+		ip := net.ParseIP(field)
+		if ip == nil {
+			return fmt.Errorf("无效的IP地址: %s", field)
+		}
+		if d.Hosts == nil {
+			d.Hosts = make(map[string][]net.IP)
+		}
+		d.Hosts[host] = append(d.Hosts[host], ip)
+	}
+	return nil
+}
+
+// 内网常用的域名后缀，这类域名解析到内网地址是正常的
+var privateNameSuffixes = []string{".local", ".lan", ".localdomain", ".internal", ".intranet", ".corp", ".home.arpa"}
+
+// 判断是否为内网域名：单标签名称或内网常用后缀
+func isPrivateName(host string) bool {
+	if !strings.Contains(host, ".") {
+		return true
+	}
+	for _, suffix := range privateNameSuffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 解析 dnsHijackHost 配置：只能是公网域名
+func (d *DNSConfig) addHijackHost(value string) error {
+	host := strings.ToLower(strings.TrimSuffix(value, "."))
+	if net.ParseIP(host) != nil || strings.ContainsAny(host, "/: ") || isPrivateName(host) {
+		return fmt.Errorf("dnsHijackHost 必须是公网域名: %s", value)
+	}
+	d.HijackHosts = append(d.HijackHosts, host)
+	return nil
+}
+
+// 解析 dohURL 配置
+func (d *DNSConfig) setDoH(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || !strings.EqualFold(u.Scheme, "https") || u.Host == "" {
+		return fmt.Errorf("dohURL 必须是 HTTPS 地址: %s", value)
+	}
+	d.DoHURL = value
+	return nil
+}
+
+// 静态解析中的地址，按网络类型过滤地址族
+func (d *DNSConfig) staticHost(network, host string) []net.IP {
+	var ips []net.IP
+	for _, ip := range d.Hosts[strings.ToLower(strings.TrimSuffix(host, "."))] {
+		if network == "tcp4" && ip.To4() == nil || network == "tcp6" && ip.To4() != nil {
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	return ips
+}
+
+// dnsDialer 按静态解析、自定义 DNS、系统 DNS 的顺序解析域名后拨号
+type dnsDialer struct {
+	dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+	config   *DNSConfig
+	resolver *net.Resolver // 自定义 DNS，未配置时为 nil
+	system   *net.Resolver // 系统 DNS，自定义 DNS 失败时使用
+}
+
+// 按配置创建拨号函数，使用自定义解析
+func newDNSDialer(config *Config, dialer *net.Dialer) *dnsDialer {
+	d := &dnsDialer{dial: dialFunc(config, dialer), config: &config.DNS, system: dialer.Resolver}
+	if d.system == nil {
+		d.system = net.DefaultResolver
+	}
+
+	// 查询 DNS 服务器时不使用源地址，UDP 连接不能使用 TCP 的本地地址
+	upstream := *dialer
+	upstream.LocalAddr = nil
+	upstream.Resolver = nil
+	if len(config.DNS.Servers) > 0 {
+		d.resolver = serverResolver(config.DNS.Servers, &upstream)
+	}
+	if config.DNS.DoHURL != "" {
+		// DoH 服务器的域名按静态解析、DNS 服务器、系统 DNS 的顺序解析
+		bootstrap := &dnsDialer{dial: d.dial, config: &config.DNS, resolver: d.resolver, system: d.system}
+		client := &http.Client{Transport: newSharedTransport(bootstrap.DialContext).base, Timeout: RequestTimeout}
+		d.resolver = dohResolver(client, config.DNS.DoHURL)
+	}
+	return d
+}
+
+// 使用指定 DNS 服务器的解析器，依次轮换服务器
+func serverResolver(servers []string, dialer *net.Dialer) *net.Resolver {
+	var next atomic.Uint32
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			server := servers[int(next.Add(1)-1)%len(servers)]
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// 使用 DNS over HTTPS 的解析器
+func dohResolver(client *http.Client, dohURL string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return &dohConn{ctx: ctx, client: client, url: dohURL}, nil
+		},
+	}
+}
+
+// DialContext 解析域名后依次尝试各地址
+func (d *dnsDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return d.dial(ctx, network, addr)
+	}
+	ips, err := d.lookup(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := d.dial(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// 解析域名：静态解析优先，其次自定义 DNS，失败时（如认证前门户拦截了 DNS）改用系统 DNS
+func (d *dnsDialer) lookup(ctx context.Context, network, host string) ([]net.IP, error) {
+	if ips := d.config.staticHost(network, host); len(ips) > 0 {
+		logCtx(ctx, DEBUG, "%s 使用静态解析: %v", host, ips)
+		return ips, nil
+	}
+
+	ipNetwork := "ip"
+	switch network {
+	case "tcp4":
+		ipNetwork = "ip4"
+	case "tcp6":
+		ipNetwork = "ip6"
+	}
+	if d.resolver != nil {
+		ips, err := d.resolver.LookupIP(ctx, ipNetwork, host)
+		if err == nil {
+			logCtx(ctx, DEBUG, "%s 经自定义DNS解析为: %v", host, ips)
+			return ips, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		logCtx(ctx, DEBUG, "自定义DNS解析 %s 失败: %v，改用系统DNS", host, err)
+	}
+	return d.system.LookupIP(ctx, ipNetwork, host)
+}
+
+// dohConn 将 Go 解析器发出的 TCP 格式 DNS 报文转为 DoH 请求 (RFC 8484)
+type dohConn struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+	wbuf   bytes.Buffer
+	rbuf   bytes.Buffer
+}
+
+// Write 收到完整的查询报文后发送 DoH 请求，响应加上长度前缀后供 Read 读取
+func (c *dohConn) Write(b []byte) (int, error) {
+	c.wbuf.Write(b)
+	for c.wbuf.Len() >= 2 {
+		data := c.wbuf.Bytes()
+		n := int(binary.BigEndian.Uint16(data))
+		if len(data) < 2+n {
+			break
+		}
+		query := make([]byte, n)
+		copy(query, data[2:2+n])
+		c.wbuf.Next(2 + n)
+
+		reply, err := c.exchange(query)
+		if err != nil {
+			return 0, err
+		}
+		var length [2]byte
+		binary.BigEndian.PutUint16(length[:], uint16(len(reply)))
+		c.rbuf.Write(length[:])
+		c.rbuf.Write(reply)
+	}
+	return len(b), nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.rbuf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.rbuf.Read(b)
+}
+
+// 发送一个 DoH 查询
+func (c *dohConn) exchange(query []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPost, c.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, fmt.Errorf("创建DoH请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("DoH请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH请求失败 (状态码: %d)", resp.StatusCode)
+	}
+	reply, err := readBody(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取DoH响应失败: %v", err)
+	}
+	if len(reply) > 0xffff {
+		return nil, errors.New("DoH响应过长")
+	}
+	return reply, nil
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr(c.url) }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr(c.url) }
+func (c *dohConn) SetDeadline(t time.Time) error      { return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type dohAddr string
+
+func (a dohAddr) Network() string { return "doh" }
+func (a dohAddr) String() string  { return string(a) }
+
+// 判断是否为公网地址，公网域名被解析到内网地址时视为劫持
+func isPublicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !cgnatNet.Contains(ip)
+}
+
+// DNS 劫持检测的域名：内置探测目标中的公网域名与 dnsHijackHost，
+// 自定义探测目标可能是解析到内网地址的校内域名，不参与检测
+func hijackCheckHosts(config *Config) []string {
+	builtin := make(map[string]bool)
+	for _, probe := range append(slices.Clone(defaultProbes), defaultHTTPSProbes...) {
+		builtin[probe.URL] = true
+	}
+
+	var hosts []string
+	add := func(host string) {
+		host = strings.ToLower(host)
+		if net.ParseIP(host) != nil || isPrivateName(host) || config.DNS.Hosts[host] != nil || slices.Contains(hosts, host) {
+			return
+		}
+		hosts = append(hosts, host)
+	}
+	for _, probe := range config.Probes {
+		if u, err := url.Parse(probe.URL); err == nil && builtin[probe.URL] {
+			add(u.Hostname())
+		}
+	}
+	for _, host := range config.DNS.HijackHosts {
+		add(host)
+	}
+	return hosts
+}
+
+// 用系统 DNS 解析公网域名，解析结果均为内网地址时认为门户劫持了 DNS
+func detectDNSHijack(ctx context.Context, config *Config) bool {
+	if !config.DNS.HijackCheck {
+		return false
+	}
+	dialer, err := newDialer(config)
+	if err != nil {
+		logCtx(ctx, DEBUG, "跳过DNS劫持检测: %v", err)
+		return false
+	}
+	resolver := dialer.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	var hijacked atomic.Bool
+	var wg sync.WaitGroup
+	for _, host := range hijackCheckHosts(config) {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			lookupCtx, cancel := context.WithTimeout(ctx, DNSLookupTimeout)
+			defer cancel()
+			ips, err := resolver.LookupIP(lookupCtx, "ip", host)
+			if err != nil || len(ips) == 0 {
+				return
+			}
+			for _, ip := range ips {
+				if isPublicIP(ip) {
+					return
+				}
+			}
+			logCtx(ctx, WARN, "%s 被系统DNS解析为内网地址 %v，DNS 疑似被门户劫持", host, ips)
+			hijacked.Store(true)
+		}(host)
+	}
+	wg.Wait()
+	return hijacked.Load()
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHijackCheckHosts(t *testing.T) {
+	config := &Config{
+		Probes: append(slices.Clone(defaultProbes),
+			Probe{URL: "http://portal.example.edu.cn/generate_204"},
+			Probe{URL: "http://10.20.0.1/generate_204"},
+		),
+		DNS: DNSConfig{
+			Hosts:       map[string][]net.IP{"captive.apple.com": {net.ParseIP("17.253.109.201")}},
+			HijackHosts: []string{"www.example.com", "www.msftconnecttest.com"},
+		},
+	}
+	got := hijackCheckHosts(config)
+	// IP 探测目标、自定义探测目标（可能是校内域名）与静态解析的域名均不参与检测
+	want := []string{"www.gstatic.com", "www.msftconnecttest.com", "www.example.com"}
+	if !slices.Equal(got, want) {
+		t.Errorf("hijackCheckHosts() = %v, want %v", got, want)
+	}
+}
+
+func TestAddHijackHost(t *testing.T) {
+	for _, value := range []string{"www.example.com", "Example.COM."} {
+		var d DNSConfig
+		if err := d.addHijackHost(value); err != nil {
+			t.Errorf("addHijackHost(%q) error: %v", value, err)
+		}
+	}
+	for _, value := range []string{"10.20.0.1", "portal", "nas.local", "router.lan", "printer.home.arpa", "a.example.com/x"} {
+		var d DNSConfig
+		if err := d.addHijackHost(value); err == nil {
+			t.Errorf("addHijackHost(%q) 没有返回错误", value)
+		}
+	}
+}
+
+// DNS 记录类型
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+)
+
+// fakeDoH 按 RFC 8484 应答 POST 查询，只认识 records 中的域名
+type fakeDoH struct {
+	records map[string][]net.IP
+	queries atomic.Int32
+	pad     int // 应答后附加的字节数，用于构造超长响应
+}
+
+func (f *fakeDoH) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	query, _ := io.ReadAll(r.Body)
+	reply, ok := dnsReply(query, f.records)
+	if !ok {
+		http.Error(w, "bad query", http.StatusBadRequest)
+		return
+	}
+	f.queries.Add(1)
+	w.Header().Set("Content-Type", "application/dns-message")
+	w.Write(append(reply, make([]byte, f.pad)...))
+}
+
+// 构造应答：复制查询的 ID 与问题，按问题类型附加 A 或 AAAA 记录，名称使用指向问题的压缩指针
+func dnsReply(query []byte, records map[string][]net.IP) ([]byte, bool) {
+	if len(query) < 12 {
+		return nil, false
+	}
+	// 问题中的域名
+	var labels []string
+	off := 12
+	for off < len(query) && query[off] != 0 {
+		n := int(query[off])
+		if off+1+n > len(query) {
+			return nil, false
+		}
+		labels = append(labels, string(query[off+1:off+1+n]))
+		off += 1 + n
+	}
+	if off+5 > len(query) {
+		return nil, false
+	}
+	qtype := binary.BigEndian.Uint16(query[off+1 : off+3])
+	question := query[12 : off+5]
+
+	var answers [][]byte
+	for _, ip := range records[strings.ToLower(strings.Join(labels, "."))] {
+		rdata := ip.To4()
+		typ := uint16(dnsTypeA)
+		if rdata == nil {
+			rdata, typ = ip.To16(), dnsTypeAAAA
+		}
+		if typ != qtype {
+			continue
+		}
+		rr := []byte{0xc0, 0x0c}
+		rr = binary.BigEndian.AppendUint16(rr, typ)
+		rr = binary.BigEndian.AppendUint16(rr, 1) // IN
+		rr = binary.BigEndian.AppendUint32(rr, 60)
+		rr = binary.BigEndian.AppendUint16(rr, uint16(len(rdata)))
+		answers = append(answers, append(rr, rdata...))
+	}
+
+	reply := append([]byte{}, query[0:2]...)
+	reply = binary.BigEndian.AppendUint16(reply, 0x8180) // 响应、期望递归、可递归
+	reply = binary.BigEndian.AppendUint16(reply, 1)
+	reply = binary.BigEndian.AppendUint16(reply, uint16(len(answers)))
+	reply = binary.BigEndian.AppendUint32(reply, 0)
+	reply = append(reply, question...)
+	for _, rr := range answers {
+		reply = append(reply, rr...)
+	}
+	return reply, true
+}
+
+// 构造 A 记录查询
+func dnsQuery(id uint16, name string) []byte {
+	query := binary.BigEndian.AppendUint16(nil, id)
+	query = binary.BigEndian.AppendUint16(query, 0x0100) // 期望递归
+	query = binary.BigEndian.AppendUint16(query, 1)
+	query = binary.BigEndian.AppendUint32(query, 0)
+	query = binary.BigEndian.AppendUint16(query, 0)
+	for _, label := range strings.Split(name, ".") {
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	query = append(query, 0)
+	query = binary.BigEndian.AppendUint16(query, dnsTypeA)
+	return binary.BigEndian.AppendUint16(query, 1)
+}
+
+// 加上 TCP 格式的长度前缀
+func withLength(msg []byte) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...)
+}
+
+var testDoHRecords = map[string][]net.IP{
+	"portal.example.edu.cn": {net.ParseIP("10.20.0.5"), net.ParseIP("2001:db8::5")},
+	"www.example.com":       {net.ParseIP("93.184.215.14")},
+}
+
+func TestDoHResolver(t *testing.T) {
+	fake := &fakeDoH{records: testDoHRecords}
+	srv := httptest.NewTLSServer(fake)
+	defer srv.Close()
+	resolver := dohResolver(srv.Client(), srv.URL+"/dns-query")
+	ctx := context.Background()
+
+	// 同时查询 A 与 AAAA，解析器为每个查询分别拨号
+	ips, err := resolver.LookupIP(ctx, "ip", "portal.example.edu.cn")
+	if err != nil {
+		t.Fatalf("LookupIP() error: %v", err)
+	}
+	got := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		got = append(got, ip.String())
+	}
+	slices.Sort(got)
+	if want := []string{"10.20.0.5", "2001:db8::5"}; !slices.Equal(got, want) {
+		t.Errorf("LookupIP() = %v, want %v", got, want)
+	}
+
+	ips, err = resolver.LookupIP(ctx, "ip4", "www.example.com")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.ParseIP("93.184.215.14")) {
+		t.Errorf("LookupIP(ip4) = %v, %v", ips, err)
+	}
+	if _, err := resolver.LookupIP(ctx, "ip4", "unknown.example.com"); err == nil {
+		t.Error("没有记录时 LookupIP() 没有返回错误")
+	}
+	if fake.queries.Load() < 4 {
+		t.Errorf("DoH 服务器收到 %d 个查询", fake.queries.Load())
+	}
+}
+
+func TestDoHConnFraming(t *testing.T) {
+	srv := httptest.NewServer(&fakeDoH{records: testDoHRecords})
+	defer srv.Close()
+	conn := &dohConn{ctx: context.Background(), client: srv.Client(), url: srv.URL}
+
+	// 两个查询拼接后分多次写入，长度前缀与报文都可能被拆开
+	stream := append(withLength(dnsQuery(0x1234, "portal.example.edu.cn")), withLength(dnsQuery(0x5678, "www.example.com"))...)
+	for _, chunk := range [][]byte{stream[:1], stream[1:20], stream[20 : len(stream)-3], stream[len(stream)-3:]} {
+		if n, err := conn.Write(chunk); err != nil || n != len(chunk) {
+			t.Fatalf("Write() = %d, %v, want %d", n, err, len(chunk))
+		}
+	}
+
+	// 逐字节读取，按长度前缀拆出两个应答
+	var replies bytes.Buffer
+	b := make([]byte, 1)
+	for {
+		n, err := conn.Read(b)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read() error: %v", err)
+		}
+		replies.Write(b[:n])
+	}
+	for _, want := range []struct {
+		id uint16
+		ip string
+	}{{0x1234, "10.20.0.5"}, {0x5678, "93.184.215.14"}} {
+		if replies.Len() < 2 {
+			t.Fatalf("缺少 ID %#x 的应答", want.id)
+		}
+		n := int(binary.BigEndian.Uint16(replies.Next(2)))
+		reply := replies.Next(n)
+		if len(reply) != n || binary.BigEndian.Uint16(reply) != want.id {
+			t.Fatalf("应答 ID %#x 长度 %d, want %#x", binary.BigEndian.Uint16(reply), n, want.id)
+		}
+		if !bytes.HasSuffix(reply, net.ParseIP(want.ip).To4()) {
+			t.Errorf("ID %#x 的应答中没有 %s", want.id, want.ip)
+		}
+	}
+	if replies.Len() != 0 {
+		t.Errorf("多余的 %d 字节", replies.Len())
+	}
+
+	// 不完整的查询不发送
+	conn.Write(withLength(dnsQuery(0x9abc, "www.example.com"))[:10])
+	if n, err := conn.Read(b); n != 0 || err != io.EOF {
+		t.Errorf("Read() = %d, %v, want EOF", n, err)
+	}
+}
+
+func TestDoHConnOversizedReply(t *testing.T) {
+	srv := httptest.NewServer(&fakeDoH{records: testDoHRecords, pad: 0x10000})
+	defer srv.Close()
+	conn := &dohConn{ctx: context.Background(), client: srv.Client(), url: srv.URL}
+	// 超过长度前缀能表示的范围时返回错误，不能截断长度
+	if _, err := conn.Write(withLength(dnsQuery(1, "www.example.com"))); err == nil {
+		t.Error("超长响应时 Write() 没有返回错误")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// 地址族，对应配置项 ipFamily
+const (
+	FamilyAuto = "auto" // 由系统选择，不区分地址族
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+	FamilyDual = "dual" // IPv4 与 IPv6 均需联网
+)
+
+// 各地址族的检测状态，输出到日志与状态文件
+const (
+	FamilyOnline  = "online"  // 探测返回预期内容
+	FamilyCaptive = "captive" // 被重定向到认证页面
+	FamilyFailed  = "failed"  // 探测失败
+	FamilyUnknown = "unknown" // 探测结果未达到法定数量
+)
+
+type familyKey struct{}
+
+// 解析 ipFamily 配置，返回需要分别检测的地址族，auto 返回 nil
+func parseFamilies(value string) ([]string, bool) {
+	switch strings.ToLower(value) {
+	case FamilyAuto:
+		return nil, true
+	case FamilyIPv4:
+		return []string{FamilyIPv4}, true
+	case FamilyIPv6:
+		return []string{FamilyIPv6}, true
+	case FamilyDual:
+		return []string{FamilyIPv4, FamilyIPv6}, true
+	}
+	return nil, false
+}
+
+// 将地址族附加到 context，其中的请求只使用该地址族连接
+func withFamily(ctx context.Context, family string) context.Context {
+	return context.WithValue(ctx, familyKey{}, family)
+}
+
+// 获取当前请求限定的地址族，未限定时返回空
+func familyFrom(ctx context.Context) string {
+	family, _ := ctx.Value(familyKey{}).(string)
+	return family
+}
+
+// 地址族名称，用于日志
+func familyLabel(family string) string {
+	switch family {
+	case FamilyIPv4:
+		return "IPv4"
+	case FamilyIPv6:
+		return "IPv6"
+	}
+	return family
+}
+
+// 地址族对应的拨号网络
+func familyNetwork(family string) string {
+	if family == FamilyIPv6 {
+		return "tcp6"
+	}
+	return "tcp4"
+}
+
+// 在传输层的基础上创建只使用指定地址族连接的传输层，连接池独立
+func familyTransport(base *http.Transport, family string) *http.Transport {
+	transport := base.Clone()
+	dial := base.DialContext
+	network := familyNetwork(family)
+	setDialers(transport, func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dial(ctx, network, addr)
+	})
+	return transport
+}
+
+// 适用于指定地址族的探测目标：主机为另一地址族的 IP 时跳过，域名两者均可
+func probesFor(config *Config, family string) []Probe {
+	if family == "" {
+		return config.Probes
+	}
+	probes := make([]Probe, 0, len(config.Probes))
+	for _, probe := range config.Probes {
+		u, err := url.Parse(probe.URL)
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(u.Hostname()); ip != nil && (ip.To4() != nil) != (family == FamilyIPv4) {
+			continue
+		}
+		probes = append(probes, probe)
+	}
+	return probes
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// 通用表单门户的内置检测规则：探测被重定向到登录页，或页面中直接出现密码输入框
+var formRules = []DetectRule{
+	{
+		Name:    "form-login-page",
+		Status:  http.StatusOK,
+		Body:    `(?i)<input[^>]+type\s*=\s*["']?password`,
+		Action:  ActionNeedAuth,
+		Extract: ExtractPage,
+	},
+	{
+		Name:     "form-redirect",
+		Status:   http.StatusFound,
+		Location: `(?i)login|logon|signin|portal|captive|hotspot|guest|splash|wlan|wifi`,
+		Action:   ActionNeedAuth,
+		Extract:  ExtractLocation,
+	},
+}
+
+var (
+	// 可能是用户名输入框的名称
+	formUserPattern = regexp.MustCompile(`(?i)user|account|login|name|mail|phone|mobile|uid|账号|用户`)
+	// 可能是服务条款的复选框
+	formTermsPattern = regexp.MustCompile(`(?i)agree|accept|terms|tos|policy|condition|同意|协议|条款`)
+)
+
+// formAuthenticator 通用 HTML 表单认证，用于未适配的门户
+type formAuthenticator struct{ probeAuthenticator }
+
+func (formAuthenticator) Describe() string { return "通用表单" }
+
+func (formAuthenticator) ownCredentials() {}
+
+// 获取门户页面中的登录表单，填写账号密码并提交，Cookie 由认证流程的会话保留
+func (formAuthenticator) Login(ctx context.Context, config *Config, params *AuthParams) (string, error) {
+	client := httpClient(ctx, true)
+
+	form, err := discoverPortalForm(ctx, client, params.RedirectURL)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", err
+	}
+
+	values, desc, err := fillForm(ctx, config, form)
+	if err != nil {
+		return "", err
+	}
+	logCtx(ctx, INFO, "提交门户表单到: %s %s (%s)", form.Method, form.Action, desc)
+
+	req, err := newFormRequest(ctx, form, values)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		logCtx(ctx, ERROR, "提交表单失败: %v", err)
+		return "", fmt.Errorf("提交表单失败: %v", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logCtx(ctx, ERROR, "关闭响应体失败: %v", err)
+		}
+	}()
+	if _, err := io.Copy(io.Discard, io.LimitReader(resp.Body, MaxBodySize)); err != nil {
+		logCtx(ctx, WARN, "读取表单响应失败: %v", err)
+	}
+	logCtx(ctx, INFO, "表单提交响应: 状态码 %d, 最终地址 %s", resp.StatusCode, resp.Request.URL)
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return "", fmt.Errorf("表单提交失败 (状态码: %d)", resp.StatusCode)
+	}
+	return "", nil
+}
+
+// 按表单中的输入项生成提交内容，返回隐藏密码后的描述
+func fillForm(ctx context.Context, config *Config, form *htmlForm) (url.Values, string, error) {
+	user := findInput(form, config.FormUserField, isUserInput)
+	passwd := findInput(form, config.FormPasswdField, func(in htmlInput) bool { return in.Type == "password" })
+	if user == nil && config.FormUserField == "" && passwd != nil {
+		// 名称不像用户名时，取第一个文本输入框
+		user = findInput(form, "", isTextInput)
+	}
+	if config.FormUserField != "" && user == nil {
+		return nil, "", fmt.Errorf("表单中没有用户名输入框: %s", config.FormUserField)
+	}
+	if config.FormPasswdField != "" && passwd == nil {
+		return nil, "", fmt.Errorf("表单中没有密码输入框: %s", config.FormPasswdField)
+	}
+	if passwd == nil {
+		logCtx(ctx, WARN, "表单中没有密码输入框，只提交表单中的已有字段")
+	} else if config.FormUserID == "" || config.FormPasswd == "" {
+		// 未适配的门户身份无法确认，不能提交校园网账号
+		return nil, "", errors.New("表单需要账号密码，但未配置 formUserid 与 formPasswd")
+	}
+
+	values := url.Values{}
+	var parts []string
+	add := func(name, value, shown string) {
+		values.Add(name, value)
+		parts = append(parts, name+"="+shown)
+	}
+	submitted := false
+	for i := range form.Inputs {
+		in := &form.Inputs[i]
+		if in.Name == "" {
+			continue
+		}
+		switch {
+		case in == user:
+			add(in.Name, config.FormUserID, config.FormUserID)
+		case in == passwd:
+			add(in.Name, config.FormPasswd, "******")
+		case in.Type == "checkbox" || in.Type == "radio":
+			if !in.Checked && !(in.Type == "checkbox" && isTermsInput(config, *in)) {
+				continue
+			}
+			value := in.Value
+			if value == "" {
+				value = "on"
+			}
+			add(in.Name, value, value)
+		case in.Type == "submit" || in.Type == "image":
+			// 只提交第一个按钮
+			if !submitted {
+				submitted = true
+				add(in.Name, in.Value, in.Value)
+			}
+		case in.Type == "button" || in.Type == "reset" || in.Type == "file":
+		default:
+			add(in.Name, in.Value, in.Value)
+		}
+	}
+	if len(values) == 0 {
+		return nil, "", errors.New("表单中没有可提交的字段")
+	}
+	return values, strings.Join(parts, ", "), nil
+}
+
+// 查找输入框：配置了选择器时按选择器匹配，否则按规则识别
+func findInput(form *htmlForm, selector string, heuristic func(htmlInput) bool) *htmlInput {
+	for i, in := range form.Inputs {
+		if in.Name == "" {
+			continue
+		}
+		if selector != "" && matchSelector(in, selector) || selector == "" && heuristic(in) {
+			return &form.Inputs[i]
+		}
+	}
+	return nil
+}
+
+// 选择器 "#id" 匹配 id 属性，其余匹配 name 属性
+func matchSelector(in htmlInput, selector string) bool {
+	if id, ok := strings.CutPrefix(selector, "#"); ok {
+		return in.Attrs["id"] == id
+	}
+	return in.Name == selector
+}
+
+func isTextInput(in htmlInput) bool {
+	switch in.Type {
+	case "text", "email", "tel", "number":
+		return true
+	}
+	return false
+}
+
+func isUserInput(in htmlInput) bool {
+	return isTextInput(in) && (formUserPattern.MatchString(in.Name) || formUserPattern.MatchString(in.Attrs["id"]))
+}
+
+// 判断复选框是否需要勾选：配置的选择器或看起来像服务条款
+func isTermsInput(config *Config, in htmlInput) bool {
+	for _, selector := range config.FormCheck {
+		if matchSelector(in, selector) {
+			return true
+		}
+	}
+	return formTermsPattern.MatchString(in.Name) || formTermsPattern.MatchString(in.Attrs["id"]) ||
+		formTermsPattern.MatchString(in.Value)
+}
+
+// 按表单的提交方式构造请求
+func newFormRequest(ctx context.Context, form *htmlForm, values url.Values) (*http.Request, error) {
+	if form.Method == http.MethodPost {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, form.Action, strings.NewReader(values.Encode()))
+		if err != nil {
+			return nil, fmt.Errorf("创建请求失败: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	}
+
+	// GET 表单的字段替换 action 中原有的查询参数，与浏览器一致
+	u, err := url.Parse(form.Action)
+	if err != nil {
+		return nil, fmt.Errorf("无效的表单地址: %v", err)
+	}
+	u.RawQuery = values.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	return req, nil
+}
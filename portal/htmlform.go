@@ -0,0 +1,229 @@
+package main
+
+import (
+	"html"
+	"net/url"
+	"strings"
+)
+
+// htmlTag HTML 标签
+type htmlTag struct {
+	Name  string            // 小写标签名，结束标签以 "/" 开头
+	Attrs map[string]string // 小写属性名 -> 解码后的属性值
+}
+
+// htmlForm 页面中的表单
+type htmlForm struct {
+	Action string // 已解析为绝对地址
+	Method string // 大写，默认 GET
+	Inputs []htmlInput
+}
+
+// htmlInput 表单中的输入项
+type htmlInput struct {
+	Name    string
+	Type    string // 小写，默认 text
+	Value   string
+	Checked bool
+	Attrs   map[string]string
+}
+
+// 扫描页面中的全部标签，跳过注释以及 script/style 的内容
+func scanTags(content string) []htmlTag {
+	var tags []htmlTag
+	i := 0
+	for i < len(content) {
+		start := strings.IndexByte(content[i:], '<')
+		if start < 0 {
+			break
+		}
+		i += start
+
+		if strings.HasPrefix(content[i:], "<!--") {
+			end := strings.Index(content[i+4:], "-->")
+			if end < 0 {
+				break
+			}
+			i += 4 + end + 3
+			continue
+		}
+
+		tag, n := parseTag(content[i:])
+		if n == 0 {
+			i++
+			continue
+		}
+		i += n
+		tags = append(tags, tag)
+
+		// script/style 中的内容不是标签
+		if tag.Name == "script" || tag.Name == "style" {
+			end := strings.Index(strings.ToLower(content[i:]), "</"+tag.Name)
+			if end < 0 {
+				break
+			}
+			i += end
+		}
+	}
+	return tags
+}
+
+// 解析以 '<' 开头的单个标签，返回标签与消耗的字节数，不是标签时返回 0
+func parseTag(s string) (htmlTag, int) {
+	i := 1
+	closing := false
+	if i < len(s) && s[i] == '/' {
+		closing = true
+		i++
+	}
+
+	nameStart := i
+	for i < len(s) && isTagNameChar(s[i]) {
+		i++
+	}
+	if i == nameStart {
+		return htmlTag{}, 0
+	}
+	tag := htmlTag{Name: strings.ToLower(s[nameStart:i]), Attrs: make(map[string]string)}
+	if closing {
+		tag.Name = "/" + tag.Name
+	}
+
+	for i < len(s) {
+		// 跳过空白与自闭合斜杠
+		for i < len(s) && (isSpace(s[i]) || s[i] == '/') {
+			i++
+		}
+		if i >= len(s) {
+			return htmlTag{}, 0
+		}
+		if s[i] == '>' {
+			return tag, i + 1
+		}
+
+		attrStart := i
+		for i < len(s) && !isSpace(s[i]) && s[i] != '=' && s[i] != '>' && s[i] != '/' {
+			i++
+		}
+		name := strings.ToLower(s[attrStart:i])
+
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i >= len(s) || s[i] != '=' {
+			if name != "" {
+				tag.Attrs[name] = ""
+			}
+			continue
+		}
+		i++
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i >= len(s) {
+			return htmlTag{}, 0
+		}
+
+		var value string
+		if q := s[i]; q == '"' || q == '\'' {
+			end := strings.IndexByte(s[i+1:], q)
+			if end < 0 {
+				return htmlTag{}, 0
+			}
+			value = s[i+1 : i+1+end]
+			i += end + 2
+		} else {
+			valueStart := i
+			for i < len(s) && !isSpace(s[i]) && s[i] != '>' {
+				i++
+			}
+			value = s[valueStart:i]
+		}
+		if name != "" {
+			tag.Attrs[name] = html.UnescapeString(value)
+		}
+	}
+	return htmlTag{}, 0
+}
+
+func isTagNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// 解析页面中的全部表单，action 相对于 base 解析
+func parseForms(content string, base *url.URL) []*htmlForm {
+	var forms []*htmlForm
+	var current *htmlForm
+
+	for _, tag := range scanTags(content) {
+		switch tag.Name {
+		case "form":
+			current = &htmlForm{Action: base.String(), Method: "GET"}
+			if action := strings.TrimSpace(tag.Attrs["action"]); action != "" {
+				if u, err := base.Parse(action); err == nil {
+					current.Action = u.String()
+				}
+			}
+			if method := strings.TrimSpace(tag.Attrs["method"]); method != "" {
+				current.Method = strings.ToUpper(method)
+			}
+			forms = append(forms, current)
+		case "/form":
+			current = nil
+		case "input", "button":
+			if current == nil {
+				continue
+			}
+			input := htmlInput{
+				Name:  tag.Attrs["name"],
+				Type:  strings.ToLower(tag.Attrs["type"]),
+				Value: tag.Attrs["value"],
+				Attrs: tag.Attrs,
+			}
+			_, input.Checked = tag.Attrs["checked"]
+			if input.Type == "" {
+				input.Type = "text"
+				if tag.Name == "button" {
+					input.Type = "submit"
+				}
+			}
+			current.Inputs = append(current.Inputs, input)
+		}
+	}
+	return forms
+}
+
+// 获取指定名称的输入项的值
+func (f *htmlForm) value(name string) string {
+	for _, in := range f.Inputs {
+		if in.Name == name {
+			return in.Value
+		}
+	}
+	return ""
+}
+
+// 获取全部隐藏字段
+func (f *htmlForm) hidden() []htmlInput {
+	var inputs []htmlInput
+	for _, in := range f.Inputs {
+		if in.Type == "hidden" && in.Name != "" {
+			inputs = append(inputs, in)
+		}
+	}
+	return inputs
+}
+
+// 判断表单中是否有密码输入框
+func (f *htmlForm) hasPassword() bool {
+	for _, in := range f.Inputs {
+		if in.Type == "password" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 网卡状态
+const (
+	LinkPending = "pending" // 尚未检测
+	LinkOnline  = "online"  // 无需认证或已认证
+	LinkFailed  = "failed"  // 检测或认证失败
+)
+
+// 状态文件名，位于状态目录中
+const StatusFileName = "status.json"
+
+// linkState 每个网卡独立的认证状态，共用账号等配置
+type linkState struct {
+	name      string           // 网卡名称，未配置网卡时为空
+	config    *Config          // 绑定到该网卡的配置副本
+	transport *sharedTransport // 该网卡共用的传输层，绑定网卡失败时为 nil
+
+	mu        sync.Mutex
+	logoutURL string        // 最近一次检测到的登出链接
+	remaining time.Duration // Captive Portal API 报告的会话剩余时间，0 表示未知
+	fastRetry int           // 下一次使用的快速重试间隔序号，-1 表示使用正常间隔
+	status    linkStatus
+}
+
+// linkStatus 网卡状态，输出到日志与状态文件
+type linkStatus struct {
+	Interface string            `json:"interface,omitempty"`
+	State     string            `json:"state"`
+	Error     string            `json:"error,omitempty"`
+	LastCheck time.Time         `json:"lastCheck"`
+	LastAuth  time.Time         `json:"lastAuth"`
+	NextCheck time.Time         `json:"nextCheck"`
+	LogoutURL string            `json:"logoutURL,omitempty"`
+	Families  map[string]string `json:"families,omitempty"` // 分地址族检测时各地址族的状态
+}
+
+// 按配置的网卡列表创建状态，未配置网卡时只有一个不绑定网卡的状态
+func newLinks(config *Config) []*linkState {
+	if len(config.Interfaces) <= 1 {
+		return []*linkState{newLink(config.Interface, config)}
+	}
+
+	links := make([]*linkState, 0, len(config.Interfaces))
+	for _, name := range config.Interfaces {
+		linkConfig := *config
+		linkConfig.Interface = name
+		links = append(links, newLink(name, &linkConfig))
+	}
+	return links
+}
+
+func newLink(name string, config *Config) *linkState {
+	l := &linkState{
+		name:   name,
+		config: config,
+		status: linkStatus{Interface: name, State: LinkPending},
+	}
+	if _, err := l.getTransport(); err != nil {
+		l.log(WARN, "%v，检测前将重试", err)
+	}
+	return l
+}
+
+// 获取网卡共用的传输层，尚未创建时创建；绑定网卡失败时返回错误，下次调用时重试
+func (l *linkState) getTransport() (*sharedTransport, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.transport != nil && l.transport.stale.Load() {
+		l.log(INFO, "绑定网卡失败，按网卡当前状态重建连接")
+		l.transport.closeIdle()
+		l.transport = nil
+	}
+	if l.transport == nil {
+		transport, err := newTransport(l.config)
+		if err != nil {
+			return nil, err
+		}
+		l.transport = transport
+	}
+	return l.transport, nil
+}
+
+// 丢弃传输层与其空闲连接，下次使用时按网卡当前的地址与状态重建
+func (l *linkState) resetTransport() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.transport != nil {
+		l.transport.closeIdle()
+		l.transport = nil
+	}
+}
+
+// 定时执行认证流程，直到 ctx 取消；每次结束后调用 report 输出状态。
+// 启动与休眠唤醒后立即执行并按快速重试间隔重试，网络变化时去抖动后立即执行
+func (l *linkState) run(ctx context.Context, changes <-chan int, resumes <-chan struct{}, report func()) {
+	if l.name != "" {
+		l.log(INFO, "开始管理网卡")
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	l.setNextCheck(0)
+
+	// 去抖动定时器，没有待处理的网络变化时为 nil
+	var debounce *time.Timer
+	var debounceC <-chan time.Time
+	defer func() {
+		if debounce != nil {
+			debounce.Stop()
+		}
+	}()
+
+	check := func() bool {
+		err := authProcess(ctx, l)
+		if ctx.Err() != nil {
+			return false
+		}
+		if err != nil {
+			l.log(ERROR, "认证流程失败: %v", err)
+		}
+		l.finish(err)
+		delay := l.nextCheckDelay(err)
+		l.setNextCheck(delay)
+		timer.Reset(delay)
+		report()
+		return true
+	}
+
+	for {
+		select {
+		case <-timer.C:
+			if !check() {
+				return
+			}
+		case index := <-changes:
+			if !l.affectedBy(index) {
+				continue
+			}
+			if debounce == nil {
+				debounce = time.NewTimer(NetworkChangeDebounce)
+				debounceC = debounce.C
+			} else {
+				debounce.Reset(NetworkChangeDebounce)
+			}
+		case <-debounceC:
+			debounce, debounceC = nil, nil
+			l.log(INFO, "检测到网络变化，立即检测")
+			// 网络变化后复用的连接与网卡地址可能已失效
+			l.resetTransport()
+			stopTimer(timer)
+			if !check() {
+				return
+			}
+		case <-resumes:
+			// 唤醒后会话通常已过期，立即检测并快速重试，待处理的网络变化一并处理
+			if debounce != nil {
+				debounce.Stop()
+				debounce, debounceC = nil, nil
+			}
+			l.log(INFO, "系统唤醒，立即检测")
+			l.resetTransport()
+			l.mu.Lock()
+			l.fastRetry = 0
+			l.mu.Unlock()
+			stopTimer(timer)
+			if !check() {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// 判断网络变化是否与该网卡有关，未绑定网卡时关心所有变化
+func (l *linkState) affectedBy(index int) bool {
+	if l.name == "" || index == 0 {
+		return true
+	}
+	iface, err := net.InterfaceByName(l.name)
+	return err != nil || iface.Index == index
+}
+
+// 带网卡字段的日志
+func (l *linkState) log(level int, format string, args ...interface{}) {
+	writeLog(level, l.name, format, args...)
+}
+
+// 停止定时器并清空未读取的触发
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+}
+
+// 计算下一次检测的间隔：快速重试期间失败时按快速重试间隔，会话即将到期时在到期后立即检测
+func (l *linkState) nextCheckDelay(err error) time.Duration {
+	l.mu.Lock()
+	remaining := l.remaining
+	retry := l.fastRetry
+	switch {
+	case retry < 0:
+	case err == nil || retry >= len(FastRetrySchedule):
+		l.fastRetry = -1
+		retry = -1
+	default:
+		l.fastRetry++
+	}
+	l.mu.Unlock()
+
+	if retry >= 0 {
+		delay := FastRetrySchedule[retry]
+		l.log(INFO, "快速重试: %v 后重新检测 (%d/%d)", delay, retry+1, len(FastRetrySchedule))
+		return delay
+	}
+
+	if remaining <= 0 || remaining >= CheckInterval {
+		return CheckInterval
+	}
+	delay := remaining + 2*time.Second
+	if delay < MinCheckInterval {
+		delay = MinCheckInterval
+	}
+	l.log(INFO, "会话将在 %v 后到期，%v 后重新检测", remaining, delay)
+	return delay
+}
+
+func (l *linkState) setRemaining(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.remaining = d
+}
+
+func (l *linkState) setLogoutURL(logout string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logoutURL = logout
+	l.status.LogoutURL = logout
+}
+
+func (l *linkState) setFamilies(states map[string]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.status.Families = states
+}
+
+func (l *linkState) setNextCheck(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.status.NextCheck = time.Now().Add(d)
+}
+
+// 记录认证成功的时间
+func (l *linkState) authenticated() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.status.LastAuth = time.Now()
+}
+
+// 记录一次认证流程的结果
+func (l *linkState) finish(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.status.LastCheck = time.Now()
+	if err != nil {
+		l.status.State = LinkFailed
+		l.status.Error = err.Error()
+		return
+	}
+	l.status.State = LinkOnline
+	l.status.Error = ""
+}
+
+// 获取状态快照
+func (l *linkState) snapshot() linkStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.status
+}
+
+// 访问最近记录的登出链接下线
+func (l *linkState) logout(ctx context.Context) {
+	l.mu.Lock()
+	logout := l.logoutURL
+	l.mu.Unlock()
+
+	if logout == "" {
+		l.log(INFO, "未记录到登出链接，跳过退出登出")
+		return
+	}
+	transport, err := l.getTransport()
+	if err != nil {
+		l.log(ERROR, "退出登出失败: %v", err)
+		return
+	}
+	ctx = withPhase(withSession(ctx, newSession(l.config, transport)), l.config, PhaseLogout)
+	if err := portalAuthenticator(l.config).Logout(ctx, logout); err != nil {
+		l.log(ERROR, "退出登出失败: %v", err)
+	}
+}
+
+var statusMu sync.Mutex
+
+// 输出所有网卡的状态：多网卡时记录汇总日志，配置了状态目录时写入状态文件
+func reportStatus(config *Config, links []*linkState) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	statuses := make([]linkStatus, 0, len(links))
+	parts := make([]string, 0, len(links))
+	for _, l := range links {
+		st := l.snapshot()
+		statuses = append(statuses, st)
+		parts = append(parts, st.Interface+"="+st.State)
+	}
+	if len(links) > 1 {
+		log(INFO, "网卡状态: %s", strings.Join(parts, ", "))
+	}
+
+	if config.StateDir == "" {
+		return
+	}
+	data, err := json.MarshalIndent(statuses, "", "  ")
+	if err != nil {
+		log(WARN, "生成状态失败: %v", err)
+		return
+	}
+	if err := os.MkdirAll(config.StateDir, 0700); err != nil {
+		log(WARN, "创建状态目录失败: %v", err)
+		return
+	}
+	path := filepath.Join(config.StateDir, StatusFileName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log(WARN, "写入状态文件失败: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log(WARN, "写入状态文件失败: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// 门户参数与本机地址的校验方式，对应配置项 localParams
+const (
+	LocalParamsWarn     = "warn"     // 不一致时记录警告，仍提交门户给出的值
+	LocalParamsOverride = "override" // 不一致时改用本机的值
+	LocalParamsOff      = "off"      // 不校验
+)
+
+// 清理门户参数中多余的 "=" 与空白，如 wlanuserip==3.3.3.3
+func cleanParam(value string) string {
+	// 部分门户对参数值多编码了一次，如 mac=aa%3Abb%3Acc...
+	if strings.Contains(value, "%") {
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+	}
+	return strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(value), "="))
+}
+
+// 解析 MAC 地址，支持 ":"、"-"、"." 分隔或不分隔的写法，大小写不敏感
+func normalizeMAC(value string) (net.HardwareAddr, error) {
+	value = cleanParam(value)
+	if len(value) == 12 {
+		if raw, err := hex.DecodeString(value); err == nil {
+			return net.HardwareAddr(raw), nil
+		}
+	}
+	mac, err := net.ParseMAC(value)
+	if err != nil || len(mac) != 6 {
+		return nil, fmt.Errorf("无效的MAC地址格式: %s", value)
+	}
+	return mac, nil
+}
+
+// 按门户原有的写法（分隔符与大小写）格式化 MAC 地址
+func formatMACLike(mac net.HardwareAddr, like string) string {
+	s := mac.String()
+	switch {
+	case strings.Contains(like, "-"):
+		s = strings.ReplaceAll(s, ":", "-")
+	case strings.Contains(like, "."):
+		bare := strings.ReplaceAll(s, ":", "")
+		s = bare[0:4] + "." + bare[4:8] + "." + bare[8:12]
+	case len(like) == 12:
+		s = strings.ReplaceAll(s, ":", "")
+	}
+	if strings.ContainsAny(like, "ABCDEF") {
+		s = strings.ToUpper(s)
+	}
+	return s
+}
+
+// localLink 认证使用的网卡的名称、地址与 MAC
+type localLink struct {
+	Name string
+	IPs  []net.IP
+	MAC  net.HardwareAddr
+}
+
+// 读取网卡的地址与 MAC
+func newLocalLink(iface *net.Interface) *localLink {
+	link := &localLink{Name: iface.Name, MAC: iface.HardwareAddr}
+	if addrs, err := iface.Addrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				link.IPs = append(link.IPs, ipNet.IP)
+			}
+		}
+	}
+	return link
+}
+
+// 判断网卡是否拥有指定地址
+func (l *localLink) hasIP(ip net.IP) bool {
+	for _, local := range l.IPs {
+		if local.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 网卡的首个地址，优先 IPv4，跳过链路本地地址
+func (l *localLink) addr() (net.IP, error) {
+	var fallback net.IP
+	for _, ip := range l.IPs {
+		if ip.IsLinkLocalUnicast() {
+			continue
+		}
+		if ip.To4() != nil {
+			return ip, nil
+		}
+		if fallback == nil {
+			fallback = ip
+		}
+	}
+	if fallback == nil {
+		return nil, errors.New("网卡没有可用的地址")
+	}
+	return fallback, nil
+}
+
+// 将门户给出的 wlanuserip 与 mac 与本机网卡比对，不一致时按配置警告或改用本机的值
+func checkLocalParams(ctx context.Context, config *Config, params *AuthParams) {
+	if config.LocalParams == LocalParamsOff || params.WlanUserIP == "" && params.MAC == "" {
+		return
+	}
+	iface, err := localInterface(config, params)
+	if err != nil {
+		logCtx(ctx, WARN, "无法确定认证使用的网卡，跳过本机地址校验: %v", err)
+		return
+	}
+	compareLocalParams(ctx, config.LocalParams, params, newLocalLink(iface))
+}
+
+// 按校验方式比对门户参数与网卡信息，override 时改写参数
+func compareLocalParams(ctx context.Context, mode string, params *AuthParams, link *localLink) {
+	if mode == LocalParamsOff {
+		return
+	}
+	override := mode == LocalParamsOverride
+
+	if params.WlanUserIP != "" {
+		ip := net.ParseIP(cleanParam(params.WlanUserIP))
+		if ip == nil || !link.hasIP(ip) {
+			local, err := link.addr()
+			switch {
+			case err != nil:
+				logCtx(ctx, WARN, "门户给出的地址 %s 不属于网卡 %s，且无法获取网卡地址: %v", params.WlanUserIP, link.Name, err)
+			case override:
+				logCtx(ctx, INFO, "门户给出的地址 %s 不属于网卡 %s，改用本机地址 %s", params.WlanUserIP, link.Name, local)
+				params.setParam("wlanuserip", local.String())
+				params.WlanUserIP = local.String()
+			default:
+				logCtx(ctx, WARN, "门户给出的地址 %s 不是网卡 %s 的地址 (%s)，可能经过 NAT 或使用了错误的网卡", params.WlanUserIP, link.Name, local)
+			}
+		}
+	}
+
+	if params.MAC != "" {
+		mac, err := normalizeMAC(params.MAC)
+		if len(link.MAC) != 6 {
+			logCtx(ctx, DEBUG, "网卡 %s 没有以太网 MAC 地址，跳过 MAC 校验", link.Name)
+			return
+		}
+		if err == nil && mac.String() == link.MAC.String() {
+			return
+		}
+		local := formatMACLike(link.MAC, cleanParam(params.MAC))
+		if override {
+			logCtx(ctx, INFO, "门户给出的MAC %s 与网卡 %s 不一致，改用本机MAC %s", params.MAC, link.Name, local)
+			params.setParam("mac", local)
+			params.MAC = local
+			return
+		}
+		logCtx(ctx, WARN, "门户给出的MAC %s 与网卡 %s 的MAC %s 不一致，可能启用了随机 MAC 或使用了错误的网卡", params.MAC, link.Name, local)
+	}
+}
+
+// 确定认证使用的网卡：配置的网卡、拥有 wlanuserip 的网卡或默认路由所在的网卡
+func localInterface(config *Config, params *AuthParams) (*net.Interface, error) {
+	if config.Interface != "" {
+		return net.InterfaceByName(config.Interface)
+	}
+	if config.SourceIP != nil {
+		return interfaceByIP(config.SourceIP)
+	}
+	if ip := net.ParseIP(params.WlanUserIP); ip != nil {
+		if iface, err := interfaceByIP(ip); err == nil {
+			return iface, nil
+		}
+	}
+	if _, device, err := defaultGateway(""); err == nil {
+		return net.InterfaceByName(device)
+	}
+	return nil, errors.New("未配置网卡且找不到默认路由")
+}
+
+// 同时更新重定向参数中的值，供认证模板与各后端使用
+func (p *AuthParams) setParam(key, value string) {
+	if p.Query != nil && p.Query.Has(key) {
+		p.Query.Set(key, value)
+	}
+}
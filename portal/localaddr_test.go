@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestNormalizeMAC(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // 空表示应返回错误
+	}{
+		{"aa:bb:cc:dd:ee:ff", "aa:bb:cc:dd:ee:ff"},
+		{"aa-bb-cc-dd-ee-ff", "aa:bb:cc:dd:ee:ff"},
+		{"AA-BB-CC-DD-EE-FF", "aa:bb:cc:dd:ee:ff"},
+		{"AABB.CCDD.EEFF", "aa:bb:cc:dd:ee:ff"},
+		{"aabbccddeeff", "aa:bb:cc:dd:ee:ff"},
+		{"AABBCCDDEEFF", "aa:bb:cc:dd:ee:ff"},
+		{"=11:a1:11:22:22:33", "11:a1:11:22:22:33"},
+		{"aa%3Abb%3Acc%3Add%3Aee%3Aff", "aa:bb:cc:dd:ee:ff"},
+		{"aa%2Dbb%2Dcc%2Ddd%2Dee%2Dff", "aa:bb:cc:dd:ee:ff"},
+		{"", ""},
+		{"aabbccddeeg0", ""},
+		{"00:00:5e:00:53:00:00:01", ""},
+	}
+	for _, tt := range tests {
+		mac, err := normalizeMAC(tt.in)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("normalizeMAC(%q) = %s, want error", tt.in, mac)
+			}
+			continue
+		}
+		if err != nil || mac.String() != tt.want {
+			t.Errorf("normalizeMAC(%q) = %s, %v, want %s", tt.in, mac, err, tt.want)
+		}
+	}
+}
+
+func TestFormatMACLike(t *testing.T) {
+	mac, _ := net.ParseMAC("0a:1b:2c:3d:4e:5f")
+	tests := []struct {
+		like string
+		want string
+	}{
+		{"aa:bb:cc:dd:ee:ff", "0a:1b:2c:3d:4e:5f"},
+		{"AA:BB:CC:DD:EE:FF", "0A:1B:2C:3D:4E:5F"},
+		{"aa-bb-cc-dd-ee-ff", "0a-1b-2c-3d-4e-5f"},
+		{"AA-BB-CC-DD-EE-FF", "0A-1B-2C-3D-4E-5F"},
+		{"AABB.CCDD.EEFF", "0A1B.2C3D.4E5F"},
+		{"aabb.ccdd.eeff", "0a1b.2c3d.4e5f"},
+		{"aabbccddeeff", "0a1b2c3d4e5f"},
+		{"112233445566", "0a1b2c3d4e5f"},
+	}
+	for _, tt := range tests {
+		if got := formatMACLike(mac, tt.like); got != tt.want {
+			t.Errorf("formatMACLike(%q) = %s, want %s", tt.like, got, tt.want)
+		}
+	}
+}
+
+func TestCompareLocalParams(t *testing.T) {
+	mac, _ := net.ParseMAC("0a:1b:2c:3d:4e:5f")
+	link := &localLink{
+		Name: "wlan0",
+		IPs:  []net.IP{net.ParseIP("fe80::81b:2cff:fe3d:4e5f"), net.ParseIP("2001:db8::5"), net.ParseIP("10.20.3.4")},
+		MAC:  mac,
+	}
+	tests := []struct {
+		name    string
+		mode    string
+		ip      string
+		mac     string
+		wantIP  string
+		wantMAC string
+	}{
+		{"一致", LocalParamsOverride, "10.20.3.4", "0A-1B-2C-3D-4E-5F", "10.20.3.4", "0A-1B-2C-3D-4E-5F"},
+		{"IPv6 地址一致", LocalParamsOverride, "2001:db8::5", "0a1b2c3d4e5f", "2001:db8::5", "0a1b2c3d4e5f"},
+		{"警告时保留门户的值", LocalParamsWarn, "100.64.0.9", "aa:bb:cc:dd:ee:ff", "100.64.0.9", "aa:bb:cc:dd:ee:ff"},
+		{"改用本机的值并保留写法", LocalParamsOverride, "100.64.0.9", "AA-BB-CC-DD-EE-FF", "10.20.3.4", "0A-1B-2C-3D-4E-5F"},
+		{"无法解析的MAC改用本机的值", LocalParamsOverride, "10.20.3.4", "unknown", "10.20.3.4", "0a:1b:2c:3d:4e:5f"},
+		{"不校验", LocalParamsOff, "100.64.0.9", "aa:bb:cc:dd:ee:ff", "100.64.0.9", "aa:bb:cc:dd:ee:ff"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{"wlanuserip": {tt.ip}, "mac": {tt.mac}, "wlanacname": {"NFV-BASE-02"}}
+			params := &AuthParams{WlanUserIP: tt.ip, MAC: tt.mac, Query: query}
+			compareLocalParams(context.Background(), tt.mode, params, link)
+			if params.WlanUserIP != tt.wantIP || params.MAC != tt.wantMAC {
+				t.Errorf("参数 %s, %s, want %s, %s", params.WlanUserIP, params.MAC, tt.wantIP, tt.wantMAC)
+			}
+			// 认证模板使用的查询参数同步改写
+			if query.Get("wlanuserip") != tt.wantIP || query.Get("mac") != tt.wantMAC {
+				t.Errorf("查询参数 %s, %s, want %s, %s", query.Get("wlanuserip"), query.Get("mac"), tt.wantIP, tt.wantMAC)
+			}
+		})
+	}
+
+	// 网卡没有以太网 MAC（如 PPP、隧道）时不改写 MAC
+	params := &AuthParams{MAC: "aa:bb:cc:dd:ee:ff"}
+	compareLocalParams(context.Background(), LocalParamsOverride, params, &localLink{Name: "ppp0", IPs: link.IPs})
+	if params.MAC != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("MAC = %s, want aa:bb:cc:dd:ee:ff", params.MAC)
+	}
+}
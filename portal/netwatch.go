@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// 网络变化后等待稳定的时间，期间的多次变化只触发一次检测
+const NetworkChangeDebounce = 3 * time.Second
+
+// networkWatcher 将网络变化事件分发给各网卡的认证循环
+type networkWatcher struct {
+	mu   sync.Mutex
+	subs []chan int
+}
+
+// 开始监听网络变化，当前平台不支持时各网卡只按定时器检测
+func startNetworkWatcher(ctx context.Context) *networkWatcher {
+	w := &networkWatcher{}
+	go func() {
+		if err := watchNetwork(ctx, w.notify); err != nil && ctx.Err() == nil {
+			log(WARN, "无法监听网络变化，仅按定时检测: %v", err)
+		}
+	}()
+	return w
+}
+
+// 订阅网络变化事件，事件内容为变化的网卡索引，0 表示未知
+func (w *networkWatcher) subscribe() <-chan int {
+	ch := make(chan int, 16)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subs = append(w.subs, ch)
+	return ch
+}
+
+// 分发事件，订阅者来不及处理时丢弃，去抖动后效果相同
+func (w *networkWatcher) notify(index int) {
+	if index > 0 {
+		if iface, err := net.InterfaceByIndex(index); err == nil && iface.Flags&net.FlagLoopback != 0 {
+			return
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, ch := range w.subs {
+		select {
+		case ch <- index:
+		default:
+		}
+	}
+}
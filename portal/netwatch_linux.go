@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// rtnetlink 多播组 (linux/rtnetlink.h)，syscall 包中没有定义
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv6IfAddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+)
+
+// 监听的 rtnetlink 组：网卡、地址与路由变化
+const rtnetlinkGroups = rtmgrpLink | rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr | rtmgrpIPv4Route | rtmgrpIPv6Route
+
+// 通过 rtnetlink 监听网络变化，直到 ctx 取消
+func watchNetwork(ctx context.Context, notify func(int)) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("创建netlink套接字失败: %v", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: rtnetlinkGroups}); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("绑定netlink套接字失败: %v", err)
+	}
+	// 非阻塞模式交给 Go 的网络轮询器，关闭文件即可结束读取
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("设置netlink套接字失败: %v", err)
+	}
+	// 先读取当前状态，之后只有实际变化的消息才通知
+	state := newNetlinkState()
+	state.load()
+
+	file := os.NewFile(uintptr(fd), "netlink")
+	go func() {
+		<-ctx.Done()
+		file.Close()
+	}()
+	log(DEBUG, "开始监听网络变化 (rtnetlink)")
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := file.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			// 事件过多时套接字缓冲区溢出，丢失的消息无法确定网卡，按未知网卡处理后继续监听
+			if errors.Is(err, syscall.ENOBUFS) {
+				log(DEBUG, "netlink缓冲区溢出，部分网络变化消息丢失")
+				state = newNetlinkState()
+				state.load()
+				notify(0)
+				continue
+			}
+			return fmt.Errorf("读取netlink消息失败: %v", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			// 消息被截断时无法确定网卡，按未知网卡处理
+			notify(0)
+			continue
+		}
+		for i := range msgs {
+			if index, ok := state.update(&msgs[i]); ok {
+				log(DEBUG, "网络变化: 消息类型 %d, 网卡索引 %d", msgs[i].Header.Type, index)
+				notify(index)
+			}
+		}
+	}
+}
+
+// IFA_FLAGS 属性 (linux/if_addr.h)，包含完整的 32 位地址标志，syscall 包中没有定义
+const ifaFlags = 8
+
+// 不触发检测的地址：临时地址（隐私扩展）、已弃用与尚未完成重复地址检测的地址
+const ignoredAddrFlags = syscall.IFA_F_TEMPORARY | syscall.IFA_F_DEPRECATED | syscall.IFA_F_TENTATIVE
+
+// netlinkState 已知的网卡连通状态、地址与默认路由。
+// IPv6 路由通告会定期刷新地址与路由的有效期，内核为此重复发送消息，只有新增或删除才需要重新检测
+type netlinkState struct {
+	links  map[int]bool    // 网卡索引 -> 是否已启用且连通
+	addrs  map[string]bool // 网卡索引与地址
+	routes map[string]bool // 默认路由：地址族、路由表、网卡索引与网关
+}
+
+func newNetlinkState() *netlinkState {
+	return &netlinkState{
+		links:  make(map[int]bool),
+		addrs:  make(map[string]bool),
+		routes: make(map[string]bool),
+	}
+}
+
+// 读取当前的网卡、地址与路由，读取失败的部分在收到消息时补充
+func (s *netlinkState) load() {
+	for _, proto := range []int{syscall.RTM_GETLINK, syscall.RTM_GETADDR, syscall.RTM_GETROUTE} {
+		data, err := syscall.NetlinkRIB(proto, syscall.AF_UNSPEC)
+		if err != nil {
+			continue
+		}
+		msgs, err := syscall.ParseNetlinkMessage(data)
+		if err != nil {
+			continue
+		}
+		for i := range msgs {
+			s.update(&msgs[i])
+		}
+	}
+}
+
+// 按消息更新状态，返回涉及的网卡索引与是否发生了需要重新检测的变化
+func (s *netlinkState) update(msg *syscall.NetlinkMessage) (int, bool) {
+	switch msg.Header.Type {
+	case syscall.RTM_NEWLINK, syscall.RTM_DELLINK:
+		if len(msg.Data) < syscall.SizeofIfInfomsg {
+			return 0, true
+		}
+		index := int(int32(binary.NativeEndian.Uint32(msg.Data[4:8])))
+		if msg.Header.Type == syscall.RTM_DELLINK {
+			delete(s.links, index)
+			return index, true
+		}
+		flags := binary.NativeEndian.Uint32(msg.Data[8:12])
+		running := flags&syscall.IFF_UP != 0 && flags&syscall.IFF_RUNNING != 0
+		known, ok := s.links[index]
+		s.links[index] = running
+		return index, !ok || known != running
+
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		if len(msg.Data) < syscall.SizeofIfAddrmsg {
+			return 0, true
+		}
+		index := int(binary.NativeEndian.Uint32(msg.Data[4:8]))
+		attrs, err := syscall.ParseNetlinkRouteAttr(msg)
+		if err != nil {
+			return index, true
+		}
+		flags := uint32(msg.Data[2])
+		var addr net.IP
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case ifaFlags:
+				if len(attr.Value) >= 4 {
+					flags = binary.NativeEndian.Uint32(attr.Value)
+				}
+			case syscall.IFA_LOCAL:
+				addr = net.IP(attr.Value)
+			case syscall.IFA_ADDRESS:
+				if addr == nil {
+					addr = net.IP(attr.Value)
+				}
+			}
+		}
+		key := fmt.Sprintf("%d/%s", index, addr)
+		if msg.Header.Type == syscall.RTM_DELADDR {
+			if !s.addrs[key] {
+				return index, false
+			}
+			delete(s.addrs, key)
+			return index, true
+		}
+		if flags&ignoredAddrFlags != 0 || s.addrs[key] {
+			return index, false
+		}
+		s.addrs[key] = true
+		return index, true
+
+	case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+		// 只关心默认路由，其他路由随地址一同变化
+		if len(msg.Data) < syscall.SizeofRtMsg || msg.Data[1] != 0 {
+			return 0, false
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(msg)
+		if err != nil {
+			return 0, true
+		}
+		var index int
+		var gateway net.IP
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.RTA_OIF:
+				if len(attr.Value) >= 4 {
+					index = int(binary.NativeEndian.Uint32(attr.Value))
+				}
+			case syscall.RTA_GATEWAY:
+				gateway = net.IP(attr.Value)
+			}
+		}
+		key := fmt.Sprintf("%d/%d/%d/%s", msg.Data[0], msg.Data[4], index, gateway)
+		if msg.Header.Type == syscall.RTM_DELROUTE {
+			if !s.routes[key] {
+				return index, false
+			}
+			delete(s.routes, key)
+			return index, true
+		}
+		if s.routes[key] {
+			return index, false
+		}
+		s.routes[key] = true
+		return index, true
+	}
+	return 0, false
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// 按 rtnetlink 格式构造地址消息，附带 IFA_ADDRESS 与 IFA_FLAGS 属性
+func addrMessage(typ uint16, index int, ip net.IP, flags uint32) *syscall.NetlinkMessage {
+	data := make([]byte, syscall.SizeofIfAddrmsg)
+	data[0] = syscall.AF_INET6
+	data[1] = 64
+	data[2] = byte(flags)
+	binary.NativeEndian.PutUint32(data[4:8], uint32(index))
+	data = appendAttr(data, syscall.IFA_ADDRESS, ip.To16())
+	value := make([]byte, 4)
+	binary.NativeEndian.PutUint32(value, flags)
+	data = appendAttr(data, ifaFlags, value)
+	return &syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: typ}, Data: data}
+}
+
+// 按 rtnetlink 格式构造默认路由消息
+func routeMessage(typ uint16, index int, gateway net.IP) *syscall.NetlinkMessage {
+	data := make([]byte, syscall.SizeofRtMsg)
+	data[0] = syscall.AF_INET6
+	data[4] = syscall.RT_TABLE_MAIN
+	value := make([]byte, 4)
+	binary.NativeEndian.PutUint32(value, uint32(index))
+	data = appendAttr(data, syscall.RTA_OIF, value)
+	data = appendAttr(data, syscall.RTA_GATEWAY, gateway.To16())
+	return &syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: typ}, Data: data}
+}
+
+// 构造网卡消息
+func linkMessage(typ uint16, index int, flags uint32) *syscall.NetlinkMessage {
+	data := make([]byte, syscall.SizeofIfInfomsg)
+	binary.NativeEndian.PutUint32(data[4:8], uint32(index))
+	binary.NativeEndian.PutUint32(data[8:12], flags)
+	return &syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: typ}, Data: data}
+}
+
+func appendAttr(data []byte, typ uint16, value []byte) []byte {
+	attr := make([]byte, syscall.SizeofRtAttr, syscall.SizeofRtAttr+len(value)+3)
+	binary.NativeEndian.PutUint16(attr[0:2], uint16(syscall.SizeofRtAttr+len(value)))
+	binary.NativeEndian.PutUint16(attr[2:4], typ)
+	attr = append(attr, value...)
+	for len(attr)%4 != 0 {
+		attr = append(attr, 0)
+	}
+	return append(data, attr...)
+}
+
+func TestNetlinkStateUpdate(t *testing.T) {
+	stable := net.ParseIP("2001:db8:1::1234")
+	temporary := net.ParseIP("2001:db8:1::a1b2:c3d4")
+	gateway := net.ParseIP("fe80::1")
+	up := uint32(syscall.IFF_UP | syscall.IFF_RUNNING)
+
+	steps := []struct {
+		name   string
+		msg    *syscall.NetlinkMessage
+		notify bool
+	}{
+		{"新地址", addrMessage(syscall.RTM_NEWADDR, 3, stable, syscall.IFA_F_PERMANENT), true},
+		{"路由通告刷新有效期", addrMessage(syscall.RTM_NEWADDR, 3, stable, syscall.IFA_F_PERMANENT), false},
+		{"尚未完成重复地址检测", addrMessage(syscall.RTM_NEWADDR, 3, net.ParseIP("2001:db8:1::5"), syscall.IFA_F_TENTATIVE), false},
+		{"新的临时地址", addrMessage(syscall.RTM_NEWADDR, 3, temporary, syscall.IFA_F_TEMPORARY), false},
+		{"临时地址过期删除", addrMessage(syscall.RTM_DELADDR, 3, temporary, syscall.IFA_F_TEMPORARY|syscall.IFA_F_DEPRECATED), false},
+		{"地址弃用", addrMessage(syscall.RTM_NEWADDR, 3, stable, syscall.IFA_F_DEPRECATED), false},
+		{"地址删除", addrMessage(syscall.RTM_DELADDR, 3, stable, syscall.IFA_F_DEPRECATED), true},
+		{"新默认路由", routeMessage(syscall.RTM_NEWROUTE, 3, gateway), true},
+		{"默认路由刷新有效期", routeMessage(syscall.RTM_NEWROUTE, 3, gateway), false},
+		{"默认路由删除", routeMessage(syscall.RTM_DELROUTE, 3, gateway), true},
+		{"网卡连通", linkMessage(syscall.RTM_NEWLINK, 3, up), true},
+		{"网卡属性变化", linkMessage(syscall.RTM_NEWLINK, 3, up), false},
+		{"网卡断开", linkMessage(syscall.RTM_NEWLINK, 3, syscall.IFF_UP), true},
+		{"网卡移除", linkMessage(syscall.RTM_DELLINK, 3, 0), true},
+	}
+
+	state := newNetlinkState()
+	for _, step := range steps {
+		index, notify := state.update(step.msg)
+		if notify != step.notify || index != 3 {
+			t.Errorf("%s: update() = %d, %v, want 3, %v", step.name, index, notify, step.notify)
+		}
+	}
+}
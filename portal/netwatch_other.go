@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// 其他平台暂不支持监听网络变化
+func watchNetwork(ctx context.Context, notify func(int)) error {
+	return errors.New("当前平台不支持")
+}
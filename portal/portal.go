@@ -2,16 +2,21 @@ package main
 
 import (
 	//	"bufio"
+	"context"
 	"errors"
 	"fmt"
-	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -34,28 +39,71 @@ const (
 	CheckURL         = "http://1.1.1.1/generate_204"
 	VerifyURL        = "http://www.gstatic.com/generate_204"
 	AuthEndpoint     = "http://10.20.16.5/quickauth.do"
+	DefaultGrace     = 10 * time.Second
+	MaxRedirects     = 5 // 探测时默认最多跟随的重定向次数
+	CheckInterval    = 1 * time.Minute
+	MinCheckInterval = 5 * time.Second
 )
 
 // 全局变量
 var (
 	logLevel   = INFO
 	logFile    *os.File
+	logMu      sync.Mutex
 	installDir string // 改为变量
 )
 
 // Config 配置结构体
 type Config struct {
-	UserID   string
-	Passwd   string
-	LogLevel int
+	UserID           string
+	Passwd           string
+	LogLevel         int
+	ShutdownGrace    time.Duration            // 退出时等待登出等收尾工作的最长时间
+	LogoutOnShutdown bool                     // 退出时是否主动登出
+	Probes           []Probe                  // 连通性探测目标
+	ProbeQuorum      int                      // 判定状态所需的一致探测数量，0 表示多数
+	Rules            []*DetectRule            // 按顺序匹配的门户检测规则
+	MaxRedirects     int                      // 探测时最多跟随的重定向次数
+	FollowHosts      []string                 // 探测时允许跟随的域名
+	Auth             *AuthTemplate            // 认证请求模板
+	DiscoverForm     bool                     // 是否从门户页面发现表单字段
+	WISPr            bool                     // 是否识别 WISPr 智能客户端认证
+	WISPrUserID      string                   // WISPr 认证账号，不使用校园网账号
+	WISPrPasswd      string                   // WISPr 认证密码
+	CaptiveAPI       string                   // Captive Portal API 地址，auto 表示从 DHCP 租约读取，off 表示不使用
+	PortalType       string                   // 门户类型: ggs / ruijie / srun / form
+	RuijieService    string                   // Ruijie 认证的服务名称
+	SrunACID         string                   // 深澜认证的 ac_id，为空时从重定向地址读取
+	FormUserID       string                   // 通用表单的认证账号，不使用校园网账号
+	FormPasswd       string                   // 通用表单的认证密码
+	FormUserField    string                   // 通用表单的用户名输入框，为空时自动识别
+	FormPasswdField  string                   // 通用表单的密码输入框，为空时自动识别
+	FormCheck        []string                 // 通用表单中需要勾选的复选框
+	UserAgent        string                   // 请求使用的 User-Agent
+	StateDir         string                   // 状态目录，配置后 Cookie 在多次运行间保留
+	Interface        string                   // 绑定的网卡名称
+	Interfaces       []string                 // 需要认证的网卡列表，多个网卡时分别认证
+	SourceIP         net.IP                   // 绑定的源地址
+	Trust            TrustPolicy              // 发送账号密码前的门户身份校验
+	LocalParams      string                   // 门户参数与本机地址的校验方式: warn / override / off
+	IPFamilies       []string                 // 需要分别检测的地址族，为空时由系统选择
+	VerifyProxy      string                   // 验证请求使用的代理，为空时直连
+	DNS              DNSConfig                // 自定义域名解析与 DNS 劫持检测
+	ProbeMode        string                   // 默认探测列表的探测方式: http / https / both
+	Timeouts         map[string]PhaseTimeouts // 各阶段请求的超时，未配置的阶段使用默认值
 }
 
 // AuthParams 认证参数
 type AuthParams struct {
-	WlanUserIP string
-	WlanAcName string
-	MAC        string
-	Vlan       string
+	WlanUserIP  string
+	WlanAcName  string
+	MAC         string
+	Vlan        string
+	Query       url.Values         // 重定向URL中的全部参数
+	RedirectURL string             // 指向门户页面的重定向URL
+	Form        *htmlForm          // 从门户页面发现的登录表单
+	WISPr       *wisprGatewayParam // 探测响应中的 WISPr 消息
+	PortalType  string             // 检测识别出的门户类型，为空时使用配置的 portalType
 }
 
 // 初始化 installDir
@@ -222,6 +270,21 @@ func cleanOldLogs() error {
 
 // 写日志
 func log(level int, format string, args ...interface{}) {
+	writeLog(level, "", format, args...)
+}
+
+// 带网卡字段的日志，网卡取自当前认证流程的会话，地址族取自 context
+func logCtx(ctx context.Context, level int, format string, args ...interface{}) {
+	field := linkName(ctx)
+	if family := familyFrom(ctx); family != "" {
+		// 分地址族检测时附加地址族，如 [eth0/IPv4]
+		field = strings.TrimPrefix(field+"/"+familyLabel(family), "/")
+	}
+	writeLog(level, field, format, args...)
+}
+
+// 写入一条日志，field 不为空时作为附加字段输出
+func writeLog(level int, field string, format string, args ...interface{}) {
 	if level < logLevel {
 		return
 	}
@@ -241,6 +304,12 @@ func log(level int, format string, args ...interface{}) {
 	timestamp := time.Now().Format("2006-01-02 15:04:05")
 	message := fmt.Sprintf(format, args...)
 	logEntry := fmt.Sprintf("[%s][%s] %s\n", levelStr, timestamp, message)
+	if field != "" {
+		logEntry = fmt.Sprintf("[%s][%s][%s] %s\n", levelStr, timestamp, field, message)
+	}
+
+	logMu.Lock()
+	defer logMu.Unlock()
 
 	// 写入文件
 	if logFile != nil {
@@ -273,8 +342,19 @@ func loadConfig() (*Config, error) {
 	}
 
 	config := &Config{
-		LogLevel: INFO, // 默认日志级别
+		LogLevel:      INFO, // 默认日志级别
+		ShutdownGrace: DefaultGrace,
+		MaxRedirects:  MaxRedirects,
+		CaptiveAPI:    CaptiveAPIAuto,
+		LocalParams:   LocalParamsWarn,
+		DNS:           DNSConfig{HijackCheck: true},
+		PortalType:    PortalGGS,
+		UserAgent:     DefaultUserAgent,
+		Auth:          newAuthTemplate(),
 	}
+	var probes []Probe
+	useBuiltinRules := true
+	var authParams []templateField
 	hasRequired := map[string]bool{
 		"userid": false,
 		"passwd": false,
@@ -320,6 +400,285 @@ func loadConfig() (*Config, error) {
 				log(WARN, "无效的日志级别: %s (第 %d 行)，使用默认值 INFO", value, lineNum+1)
 			}
 			log(DEBUG, "读取到 logLevel: %s", value)
+		case "shutdownGrace":
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				log(WARN, "无效的退出宽限时间: %s (第 %d 行)，使用默认值 %v", value, lineNum+1, DefaultGrace)
+				continue
+			}
+			config.ShutdownGrace = d
+			log(DEBUG, "读取到 shutdownGrace: %v", d)
+		case "logoutOnShutdown":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				log(WARN, "无效的 logoutOnShutdown 值: %s (第 %d 行)，使用默认值 false", value, lineNum+1)
+				continue
+			}
+			config.LogoutOnShutdown = b
+			log(DEBUG, "读取到 logoutOnShutdown: %v", b)
+		case "probe":
+			probe, err := parseProbe(value)
+			if err != nil {
+				log(WARN, "跳过无效的探测目标 (第 %d 行): %v", lineNum+1, err)
+				continue
+			}
+			probes = append(probes, probe)
+			log(DEBUG, "读取到 probe: %s (预期状态码 %d)", probe.URL, probe.Status)
+		case "probeMode":
+			switch strings.ToLower(value) {
+			case ProbeModeHTTP, ProbeModeHTTPS, ProbeModeBoth:
+				config.ProbeMode = strings.ToLower(value)
+			default:
+				log(WARN, "probeMode 必须是 http、https 或 both: %s (第 %d 行)，使用默认值 http", value, lineNum+1)
+				continue
+			}
+			log(DEBUG, "读取到 probeMode: %s", config.ProbeMode)
+		case "probeQuorum":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				log(WARN, "无效的 probeQuorum 值: %s (第 %d 行)，使用多数", value, lineNum+1)
+				continue
+			}
+			config.ProbeQuorum = n
+			log(DEBUG, "读取到 probeQuorum: %d", n)
+		case "detectRule":
+			rule, err := parseDetectRule(value)
+			if err != nil {
+				log(WARN, "跳过无效的检测规则 (第 %d 行): %v", lineNum+1, err)
+				continue
+			}
+			config.Rules = append(config.Rules, rule)
+			log(DEBUG, "读取到 detectRule: %s (%s)", rule.Name, rule.Action)
+		case "builtinRules":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				log(WARN, "无效的 builtinRules 值: %s (第 %d 行)，使用默认值 true", value, lineNum+1)
+				continue
+			}
+			useBuiltinRules = b
+			log(DEBUG, "读取到 builtinRules: %v", b)
+		case "wispr":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				log(WARN, "无效的 wispr 值: %s (第 %d 行)，使用默认值 false", value, lineNum+1)
+				continue
+			}
+			config.WISPr = b
+			log(DEBUG, "读取到 wispr: %v", b)
+		case "wisprUserid":
+			config.WISPrUserID = value
+			log(DEBUG, "读取到 wisprUserid: %s", value)
+		case "wisprPasswd":
+			config.WISPrPasswd = value
+			log(DEBUG, "读取到 wisprPasswd: %s", strings.Repeat("*", len(value)))
+		case "portalType":
+			if _, ok := authenticators[strings.ToLower(value)]; !ok {
+				log(WARN, "未知的门户类型: %s (第 %d 行)，可选 %s，使用默认值 %s",
+					value, lineNum+1, strings.Join(portalTypes(), "/"), PortalGGS)
+				continue
+			}
+			config.PortalType = strings.ToLower(value)
+			log(DEBUG, "读取到 portalType: %s", config.PortalType)
+		case "ruijieService":
+			config.RuijieService = value
+			log(DEBUG, "读取到 ruijieService: %s", value)
+		case "srunAcid":
+			config.SrunACID = value
+			log(DEBUG, "读取到 srunAcid: %s", value)
+		case "formUserid":
+			config.FormUserID = value
+			log(DEBUG, "读取到 formUserid: %s", value)
+		case "formPasswd":
+			config.FormPasswd = value
+			log(DEBUG, "读取到 formPasswd: %s", strings.Repeat("*", len(value)))
+		case "formUserField":
+			config.FormUserField = value
+			log(DEBUG, "读取到 formUserField: %s", value)
+		case "formPasswdField":
+			config.FormPasswdField = value
+			log(DEBUG, "读取到 formPasswdField: %s", value)
+		case "userAgent":
+			config.UserAgent = value
+			log(DEBUG, "读取到 userAgent: %s", value)
+		case "interface":
+			if slices.Contains(config.Interfaces, value) {
+				log(WARN, "重复的 interface: %s (第 %d 行)", value, lineNum+1)
+				continue
+			}
+			config.Interfaces = append(config.Interfaces, value)
+			log(DEBUG, "读取到 interface: %s", value)
+		case "sourceIP":
+			ip := net.ParseIP(value)
+			if ip == nil {
+				log(WARN, "无效的 sourceIP 值: %s (第 %d 行)", value, lineNum+1)
+				continue
+			}
+			config.SourceIP = ip
+			log(DEBUG, "读取到 sourceIP: %s", ip)
+		case "detectTimeout", "authTimeout", "verifyTimeout", "logoutTimeout":
+			timeouts, err := parseTimeouts(value)
+			if err != nil {
+				log(WARN, "无效的 %s 值: %v (第 %d 行)，使用默认值", key, err, lineNum+1)
+				continue
+			}
+			if config.Timeouts == nil {
+				config.Timeouts = make(map[string]PhaseTimeouts)
+			}
+			config.Timeouts[strings.TrimSuffix(key, "Timeout")] = timeouts
+			log(DEBUG, "读取到 %s: %+v", key, timeouts)
+		case "dnsServer":
+			if err := config.DNS.addServer(value); err != nil {
+				log(WARN, "%v (第 %d 行)", err, lineNum+1)
+				continue
+			}
+			log(DEBUG, "读取到 dnsServer: %s", value)
+		case "dnsHost":
+			if err := config.DNS.addHost(value); err != nil {
+				log(WARN, "%v (第 %d 行)", err, lineNum+1)
+				continue
+			}
+			log(DEBUG, "读取到 dnsHost: %s", value)
+		case "dohURL":
+			if err := config.DNS.setDoH(value); err != nil {
+				log(WARN, "%v (第 %d 行)", err, lineNum+1)
+				continue
+			}
+			log(DEBUG, "读取到 dohURL: %s", value)
+		case "dnsHijackCheck":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				log(WARN, "无效的 dnsHijackCheck 值: %s (第 %d 行)，使用默认值 true", value, lineNum+1)
+				continue
+			}
+			config.DNS.HijackCheck = b
+			log(DEBUG, "读取到 dnsHijackCheck: %v", config.DNS.HijackCheck)
+		case "dnsHijackHost":
+			if err := config.DNS.addHijackHost(value); err != nil {
+				log(WARN, "%v (第 %d 行)", err, lineNum+1)
+				continue
+			}
+			log(DEBUG, "读取到 dnsHijackHost: %s", value)
+		case "verifyProxy":
+			proxy, err := parseProxy(value)
+			if err != nil {
+				log(WARN, "%v (第 %d 行)，验证请求将直连", err, lineNum+1)
+				continue
+			}
+			config.VerifyProxy = proxy
+			log(DEBUG, "读取到 verifyProxy: %s", redactProxy(proxy))
+		case "ipFamily":
+			families, ok := parseFamilies(value)
+			if !ok {
+				log(WARN, "ipFamily 必须是 auto、ipv4、ipv6 或 dual: %s (第 %d 行)，使用默认值 auto", value, lineNum+1)
+				continue
+			}
+			config.IPFamilies = families
+			log(DEBUG, "读取到 ipFamily: %s", strings.ToLower(value))
+		case "localParams":
+			switch strings.ToLower(value) {
+			case LocalParamsWarn, LocalParamsOverride, LocalParamsOff:
+				config.LocalParams = strings.ToLower(value)
+			default:
+				log(WARN, "localParams 必须是 warn、override 或 off: %s (第 %d 行)，使用默认值 warn", value, lineNum+1)
+				continue
+			}
+			log(DEBUG, "读取到 localParams: %s", config.LocalParams)
+		case "trustAcName":
+			config.Trust.AcNames = append(config.Trust.AcNames, value)
+			log(DEBUG, "读取到 trustAcName: %s", value)
+		case "trustHost":
+			if err := config.Trust.addHost(value); err != nil {
+				log(WARN, "%v (第 %d 行)", err, lineNum+1)
+				continue
+			}
+			log(DEBUG, "读取到 trustHost: %s", value)
+		case "trustGatewayMAC":
+			// 无法校验时不能静默忽略，否则会在伪造热点上照常发送账号密码
+			if !gatewayMACSupported {
+				log(ERROR, "当前平台 (%s) 无法读取默认网关的MAC，不支持 trustGatewayMAC (第 %d 行)", runtime.GOOS, lineNum+1)
+				return nil, fmt.Errorf("当前平台 (%s) 不支持 trustGatewayMAC", runtime.GOOS)
+			}
+			if err := config.Trust.addGatewayMAC(value); err != nil {
+				log(WARN, "%v (第 %d 行)", err, lineNum+1)
+				continue
+			}
+			log(DEBUG, "读取到 trustGatewayMAC: %s", value)
+		case "stateDir":
+			if !filepath.IsAbs(value) {
+				value = filepath.Join(installDir, value)
+			}
+			config.StateDir = value
+			log(DEBUG, "读取到 stateDir: %s", value)
+		case "formCheck":
+			config.FormCheck = append(config.FormCheck, value)
+			log(DEBUG, "读取到 formCheck: %s", value)
+		case "captiveAPI":
+			switch strings.ToLower(value) {
+			case CaptiveAPIAuto, CaptiveAPIOff:
+				config.CaptiveAPI = strings.ToLower(value)
+			default:
+				if !strings.HasPrefix(strings.ToLower(value), "https://") {
+					log(WARN, "captiveAPI 必须是 HTTPS 地址、auto 或 off: %s (第 %d 行)，使用默认值 auto", value, lineNum+1)
+					continue
+				}
+				config.CaptiveAPI = value
+			}
+			log(DEBUG, "读取到 captiveAPI: %s", config.CaptiveAPI)
+		case "maxRedirects":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				log(WARN, "无效的 maxRedirects 值: %s (第 %d 行)，使用默认值 %d", value, lineNum+1, MaxRedirects)
+				continue
+			}
+			config.MaxRedirects = n
+			log(DEBUG, "读取到 maxRedirects: %d", n)
+		case "followHost":
+			host := strings.ToLower(strings.TrimPrefix(value, "."))
+			if host == "" {
+				continue
+			}
+			config.FollowHosts = append(config.FollowHosts, host)
+			log(DEBUG, "读取到 followHost: %s", host)
+		case "authMethod":
+			method, err := parseAuthMethod(value)
+			if err != nil {
+				log(WARN, "%v (第 %d 行)，使用默认值 GET", err, lineNum+1)
+				continue
+			}
+			config.Auth.Method = method
+			log(DEBUG, "读取到 authMethod: %s", method)
+		case "authURL":
+			tmpl, err := parseTemplate("authURL", value)
+			if err != nil {
+				log(WARN, "无效的 authURL 模板 (第 %d 行): %v", lineNum+1, err)
+				continue
+			}
+			config.Auth.URL = tmpl
+			log(DEBUG, "读取到 authURL: %s", value)
+		case "authParam":
+			field, err := parseTemplateField(value, "=")
+			if err != nil {
+				log(WARN, "跳过无效的 authParam (第 %d 行): %v", lineNum+1, err)
+				continue
+			}
+			authParams = append(authParams, field)
+			log(DEBUG, "读取到 authParam: %s", field.Name)
+		case "authHeader":
+			field, err := parseTemplateField(value, ":")
+			if err != nil {
+				log(WARN, "跳过无效的 authHeader (第 %d 行): %v", lineNum+1, err)
+				continue
+			}
+			config.Auth.Headers = append(config.Auth.Headers, field)
+			log(DEBUG, "读取到 authHeader: %s", field.Name)
+		case "discoverForm":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				log(WARN, "无效的 discoverForm 值: %s (第 %d 行)，使用默认值 false", value, lineNum+1)
+				continue
+			}
+			config.DiscoverForm = b
+			log(DEBUG, "读取到 discoverForm: %v", b)
 		default:
 			log(WARN, "跳过未知配置项: %s (第 %d 行)", key, lineNum+1)
 		}
@@ -332,6 +691,68 @@ func loadConfig() (*Config, error) {
 		return nil, errors.New("配置文件中缺少必要参数")
 	}
 
+	// WISPr 热点不在学校控制之下，不能提交校园网账号
+	if config.PortalType == PortalWISPr {
+		config.WISPr = true
+	}
+	if config.WISPr && (config.WISPrUserID == "" || config.WISPrPasswd == "") {
+		log(WARN, "已启用 wispr 但未配置 wisprUserid 与 wisprPasswd，检测到 WISPr 热点时将不会认证")
+	}
+
+	if config.PortalType == PortalForm && (config.FormUserID == "" || config.FormPasswd == "") {
+		log(WARN, "portalType 为 form 但未配置 formUserid 与 formPasswd，只能通过无需账号密码的门户")
+	}
+
+	// 未配置探测目标时按探测方式使用默认列表
+	if len(probes) == 0 {
+		probes = defaultProbeList(config.ProbeMode)
+	} else if config.ProbeMode != "" {
+		log(WARN, "已配置 probe，忽略 probeMode")
+	}
+	config.Probes = probes
+	if config.ProbeQuorum > len(probes) {
+		log(WARN, "probeQuorum %d 超过探测目标数量 %d，使用多数", config.ProbeQuorum, len(probes))
+		config.ProbeQuorum = 0
+	}
+
+	// 配置了认证参数时替换默认参数列表
+	if len(authParams) > 0 {
+		config.Auth.Params = authParams
+	}
+
+	// 自定义规则优先，内置规则兜底
+	if useBuiltinRules {
+		config.Rules = append(config.Rules, builtinRules(config.PortalType)...)
+	}
+	if len(config.Rules) == 0 {
+		log(ERROR, "没有可用的检测规则")
+		return nil, errors.New("没有可用的检测规则")
+	}
+	log(DEBUG, "检测规则: %s", describeRules(config.Rules))
+
+	// 检查绑定的网卡与源地址
+	if len(config.Interfaces) == 1 {
+		config.Interface = config.Interfaces[0]
+	}
+	if len(config.Interfaces) > 1 && config.SourceIP != nil {
+		log(ERROR, "配置了多个网卡时不能使用 sourceIP")
+		return nil, errors.New("配置了多个网卡时不能使用 sourceIP")
+	}
+	for _, name := range config.Interfaces {
+		linkConfig := *config
+		linkConfig.Interface = name
+		if err := checkBinding(&linkConfig); err != nil {
+			log(ERROR, "%v", err)
+			return nil, err
+		}
+	}
+	if len(config.Interfaces) == 0 {
+		if err := checkBinding(config); err != nil {
+			log(ERROR, "%v", err)
+			return nil, err
+		}
+	}
+
 	logLevel = config.LogLevel
 	log(DEBUG, "配置文件加载成功")
 	return config, nil
@@ -359,259 +780,238 @@ passwd=
 	return nil, fmt.Errorf("请编辑配置文件后重新运行: %s", configPath)
 }
 
-// 检测网络状态并获取认证信息
-func checkNetworkStatus() (string, *AuthParams, error) {
-	log(DEBUG, "开始检测网络状态")
-
-	client := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
-		Timeout: 10 * time.Second,
-	}
-
-	log(DEBUG, "发送请求到: %s", CheckURL)
-	resp, err := client.Get(CheckURL)
-	if err != nil {
-		if strings.Contains(err.Error(), "timeout") {
-			log(INFO, "请求超时，可能不在网络内")
-			return "", nil, errors.New("网络超时，可能不在网络内")
-		}
-		log(ERROR, "请求失败: %v", err)
-		return "", nil, fmt.Errorf("请求失败: %v", err)
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			log(ERROR, "关闭响应体失败: %v", err)
-		}
-	}()
-	log(DEBUG, "收到响应状态码: %d", resp.StatusCode)
-
-	// 1. 处理301响应 (Cloudflare检测)
-	if resp.StatusCode == http.StatusMovedPermanently {
-		serverHeader := resp.Header.Get("Server")
-		if strings.Contains(strings.ToLower(serverHeader), "cloudflare") {
-			log(INFO, "检测到Cloudflare服务器，疑似不在网络内")
-			return "", nil, errors.New("检测到Cloudflare，疑似不在网络内")
-		}
-		return "", nil, nil
-	}
-
-	// 2. 处理200响应 (portal.do检测)
-	if resp.StatusCode == http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log(ERROR, "读取响应体失败: %v", err)
-			return "", nil, fmt.Errorf("读取响应失败: %v", err)
-		}
-
-		// 检查响应体中是否包含portal.do
-		if strings.Contains(string(body), "portal.do") {
-			log(INFO, "检测到portal.do页面，需要认证")
-
-			// 尝试从响应体中提取重定向URL
-			redirectURL := extractRedirectURL(string(body))
-			if redirectURL == "" {
-				log(ERROR, "在200响应中找到portal.do但未提取到重定向URL")
-				return "", nil, errors.New("portal.do页面中未找到重定向URL")
-			}
-
-			params, err := parseAuthParams(redirectURL)
-			if err != nil {
-				return "", nil, err
-			}
-			return "NEED_AUTH", params, nil
-		}
-
-		log(INFO, "200响应但未检测到portal.do内容")
-		return "", nil, nil
-	}
-
-	// 3. 处理302响应 (登出链接检测和portalScript.do检测)
-	if resp.StatusCode == http.StatusFound {
-		location := resp.Header.Get("Location")
-		if location == "" {
-			log(ERROR, "302重定向响应中没有Location头")
-			return "", nil, errors.New("重定向响应中没有Location头")
-		}
-
-		log(DEBUG, "获取到重定向Location: %s", location)
-
-		// 检测portalLogout.do (已认证)
-		if strings.Contains(location, "portalLogout.do") {
-			log(INFO, "当前已认证，无需认证，登出链接: %s", location)
-			return location, nil, nil
-		}
-
-		// 检测portalScript.do (需要认证)
-		if strings.Contains(location, "portalScript.do") {
-			log(INFO, "检测到portalScript.do，需要认证")
-			params, err := parseAuthParams(location)
-			if err != nil {
-				return "", nil, err
-			}
-			return "NEED_AUTH", params, nil
-		}
-
-		log(INFO, "302重定向但未检测到portalLogout.do或portalScript.do")
-		return "", nil, nil
-	}
-
-	// 其他状态码处理
-	log(INFO, "未检测到需要认证的情况 (状态码: %d)", resp.StatusCode)
-	return "", nil, nil
-}
-
-// 从HTML/JavaScript内容中提取重定向URL
-func extractRedirectURL(content string) string {
-	// 1. 尝试匹配 location.replace(...)
-	re := regexp.MustCompile(`location\.replace\("([^"]+)"\)`)
-	matches := re.FindStringSubmatch(content)
-	if len(matches) > 1 {
-		return matches[1]
+// 按门户类型解析重定向URL中的认证参数
+func parsePortalParams(ctx context.Context, config *Config, redirectURL string) (*AuthParams, error) {
+	if config.PortalType != PortalGGS {
+		// 其他门户只需要重定向地址中的部分参数，不校验
+		return parseRedirectParams(ctx, redirectURL)
 	}
-
-	// 2. 尝试匹配 portal.do 的直接链接
-	//re = regexp.MustCompile(`(http?://[^"']+portal\.do[^"']*)`)
-	re = regexp.MustCompile(`(https?://[^\s"']+?portal\.do[^\s"']*?)(?:&url=|$)`)
-
-	matches = re.FindStringSubmatch(content)
-	if len(matches) > 0 {
-		return matches[1]
-	}
-
-	return ""
+	return parseAuthParams(ctx, redirectURL)
 }
 
-// 从重定向URL解析认证参数
-func parseAuthParams(redirectURL string) (*AuthParams, error) {
-	log(DEBUG, "开始解析认证参数，URL: %s", redirectURL)
+// 解析重定向URL中的参数，不做校验
+func parseRedirectParams(ctx context.Context, redirectURL string) (*AuthParams, error) {
+	logCtx(ctx, DEBUG, "开始解析认证参数，URL: %s", redirectURL)
 
 	u, err := url.Parse(redirectURL)
 	if err != nil {
-		log(ERROR, "解析URL失败: %v", err)
+		logCtx(ctx, ERROR, "解析URL失败: %v", err)
 		return nil, fmt.Errorf("解析URL失败: %v", err)
 	}
 
 	query := u.Query()
+	// 部分门户的重定向地址形如 wlanuserip==3.3.3.3，去掉多余的 "="
+	for _, key := range []string{"wlanuserip", "mac"} {
+		if query.Has(key) {
+			query.Set(key, cleanParam(query.Get(key)))
+		}
+	}
 	params := &AuthParams{
-		WlanUserIP: query.Get("wlanuserip"),
-		WlanAcName: query.Get("wlanacname"),
-		MAC:        query.Get("mac"),
-		Vlan:       query.Get("vlan"),
+		WlanUserIP:  query.Get("wlanuserip"),
+		WlanAcName:  query.Get("wlanacname"),
+		MAC:         query.Get("mac"),
+		Vlan:        query.Get("vlan"),
+		Query:       query,
+		RedirectURL: redirectURL,
 	}
 
-	log(DEBUG, "解析到的参数: wlanuserip=%s, wlanacname=%s, mac=%s, vlan=%s",
+	logCtx(ctx, DEBUG, "解析到的参数: wlanuserip=%s, wlanacname=%s, mac=%s, vlan=%s",
 		params.WlanUserIP, params.WlanAcName, params.MAC, params.Vlan)
+	return params, nil
+}
+
+// 从重定向URL解析认证参数
+func parseAuthParams(ctx context.Context, redirectURL string) (*AuthParams, error) {
+	params, err := parseRedirectParams(ctx, redirectURL)
+	if err != nil {
+		return nil, err
+	}
 
 	// 验证MAC地址格式
-	macRegex := regexp.MustCompile(`^([0-9A-Fa-f]{2}[:-]){5}([0-9A-Fa-f]{2})$`)
-	if !macRegex.MatchString(params.MAC) {
-		log(ERROR, "无效的MAC地址格式: %s", params.MAC)
-		return nil, fmt.Errorf("无效的MAC地址格式: %s", params.MAC)
+	if _, err := normalizeMAC(params.MAC); err != nil {
+		logCtx(ctx, ERROR, "%v", err)
+		return nil, err
 	}
 
 	if params.WlanUserIP == "" || params.WlanAcName == "" {
-		log(ERROR, "缺少必要的认证参数 (wlanuserip或wlanacname为空)")
+		logCtx(ctx, ERROR, "缺少必要的认证参数 (wlanuserip或wlanacname为空)")
 		return nil, errors.New("缺少必要的认证参数")
 	}
+	if net.ParseIP(params.WlanUserIP) == nil {
+		logCtx(ctx, ERROR, "无效的wlanuserip: %s", params.WlanUserIP)
+		return nil, fmt.Errorf("无效的wlanuserip: %s", params.WlanUserIP)
+	}
 
-	log(INFO, "认证参数解析成功")
+	logCtx(ctx, INFO, "认证参数解析成功")
 	return params, nil
 }
 
 // 执行认证请求
-func doAuth(config *Config, params *AuthParams) error {
-	log(INFO, "开始执行认证请求")
-
-	//rawURL := fmt.Sprintf("%s?userid=%s&passwd=%s&wlanacname=%s&portalpageid=2&mac=%s&wlanuserip=%s",
-	//	AuthEndpoint,
-	//	config.UserID,
-	//	config.Passwd,
-	//	params.WlanAcName,
-	//	params.MAC,
-	//	params.WlanUserIP,
-	//)
-	//log(DEBUG, "未编码的认证 URL: %s", strings.Replace(rawURL, config.Passwd, "******", 1))
-
-	// 构造认证URL
-	authURL := fmt.Sprintf("%s?userid=%s&passwd=%s&wlanacname=%s&portalpageid=2&mac=%s&wlanuserip=%s",
-		AuthEndpoint,
-		url.QueryEscape(config.UserID),
-		url.QueryEscape(config.Passwd),
-		url.QueryEscape(params.WlanAcName),
-		url.QueryEscape(params.MAC),
-		url.QueryEscape(params.WlanUserIP),
-	)
-
-	log(DEBUG, "构造的认证URL: %s (密码已隐藏)", strings.Replace(authURL, url.QueryEscape(config.Passwd), "******", 1))
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	log(DEBUG, "发送认证请求")
-	resp, err := client.Get(authURL)
+func doAuth(ctx context.Context, config *Config, params *AuthParams) error {
+	logCtx(ctx, INFO, "开始执行认证请求")
+
+	// 按模板构造认证请求
+	req, desc, err := config.Auth.build(ctx, config, params)
+	if err != nil {
+		logCtx(ctx, ERROR, "构造认证请求失败: %v", err)
+		return err
+	}
+
+	logCtx(ctx, DEBUG, "构造的认证请求: %s (密码已隐藏)", desc)
+	if err := checkCredentialTarget(ctx, config, req.URL.String()); err != nil {
+		return err
+	}
+
+	client := httpClient(ctx, true)
+	logCtx(ctx, DEBUG, "发送认证请求")
+	resp, err := client.Do(req)
 	if err != nil {
-		log(ERROR, "认证请求失败: %v", err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logCtx(ctx, ERROR, "认证请求失败: %v", err)
 		return fmt.Errorf("认证请求失败: %v", err)
 	}
 	var closeErr error
 	defer func() {
 		closeErr = resp.Body.Close()
 		if closeErr != nil {
-			log(ERROR, "关闭响应体失败: %v", closeErr)
+			logCtx(ctx, ERROR, "关闭响应体失败: %v", closeErr)
 		}
 	}()
-	log(DEBUG, "收到认证响应状态码: %d", resp.StatusCode)
+	logCtx(ctx, DEBUG, "收到认证响应状态码: %d", resp.StatusCode)
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := readBody(resp.Body)
 	if err != nil {
-		log(ERROR, "读取响应体失败: %v", err)
+		logCtx(ctx, ERROR, "读取响应体失败: %v", err)
 		return fmt.Errorf("读取响应失败: %v", err)
 	}
 
-	log(INFO, "认证响应: %s", string(body))
+	logCtx(ctx, INFO, "认证响应: %s", string(body))
 	return nil
 }
 
 // 验证认证状态
-func verifyAuth() (bool, error) {
-	log(DEBUG, "开始验证认证状态")
+func verifyAuth(ctx context.Context, config *Config) (bool, error) {
+	logCtx(ctx, DEBUG, "开始验证认证状态")
 
-	time.Sleep(2 * time.Second)
-	log(DEBUG, "等待 2 秒")
+	logCtx(ctx, DEBUG, "等待 2 秒")
+	if err := sleepContext(ctx, 2*time.Second); err != nil {
+		return false, err
+	}
 
-	log(DEBUG, "发送验证请求到: %s", VerifyURL)
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(VerifyURL)
-	if err != nil {
-		log(ERROR, "验证请求失败: %v", err)
-		return false, fmt.Errorf("验证请求失败: %v", err)
+	if config.VerifyProxy != "" {
+		logCtx(ctx, INFO, "验证请求使用代理: %s", redactProxy(config.VerifyProxy))
+		ctx = withProxy(ctx, config.VerifyProxy)
 	}
 
-	var closeErr error
-	defer func() {
-		closeErr = resp.Body.Close()
-		if closeErr != nil {
-			log(ERROR, "关闭响应体失败: %v", closeErr)
+	if len(config.IPFamilies) == 0 {
+		return verifyFamily(ctx, config)
+	}
+
+	// 分地址族验证，所有配置的地址族均联网才算成功
+	sess := sessionFrom(ctx)
+	verified := true
+	for _, family := range config.IPFamilies {
+		ok, err := verifyFamily(withFamily(ctx, family), config)
+		if err != nil {
+			return false, err
 		}
-	}()
+		state := FamilyOnline
+		if !ok {
+			state, verified = FamilyFailed, false
+		}
+		if sess != nil {
+			sess.setFamilyState(family, state)
+		}
+	}
+	return verified, nil
+}
 
-	log(DEBUG, "验证响应状态码: %d", resp.StatusCode)
+// 按当前 context 限定的地址族验证是否已联网
+func verifyFamily(ctx context.Context, config *Config) (bool, error) {
+	results := runProbes(ctx, config)
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
 
-	if resp.StatusCode == http.StatusNoContent {
-		log(INFO, "验证成功 (收到204状态码)")
+	online := 0
+	for _, r := range results {
+		if r.State == ProbeOnline || r.State == ProbeLoggedIn {
+			online++
+		}
+	}
+
+	quorum := probeQuorum(config, len(results))
+	if len(results) > 0 && online >= quorum {
+		logCtx(ctx, INFO, "验证成功 (%d/%d 个探测在线)", online, len(results))
 		return true, nil
 	}
 
-	log(WARN, "验证未通过 (收到状态码: %d)", resp.StatusCode)
+	logCtx(ctx, WARN, "验证未通过 (%d/%d 个探测在线，需要 %d 个)", online, len(results), quorum)
 	return false, nil
 }
 
-// 主认证流程
-func authProcess(config *Config) error {
-	log(DEBUG, "启动认证流程")
+// 执行登出请求
+func doLogout(ctx context.Context, logout string) error {
+	logCtx(ctx, INFO, "开始执行登出请求")
+	logCtx(ctx, DEBUG, "发送登出请求到: %s", logout)
+
+	client := httpClient(ctx, true)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, logout, nil)
+	if err != nil {
+		return fmt.Errorf("创建登出请求失败: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		logCtx(ctx, ERROR, "登出请求失败: %v", err)
+		return fmt.Errorf("登出请求失败: %v", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logCtx(ctx, ERROR, "关闭响应体失败: %v", err)
+		}
+	}()
+
+	logCtx(ctx, INFO, "登出响应状态码: %d", resp.StatusCode)
+	return nil
+}
+
+// 可被取消的等待
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 主认证流程，每个网卡独立执行
+func authProcess(ctx context.Context, link *linkState) error {
+	config := link.config
+
+	// 检测、认证与验证共用一个会话，保留门户设置的 Cookie
+	// 绑定网卡失败时跳过本次检测，不能从其他网卡认证
+	transport, err := link.getTransport()
+	if err != nil {
+		return fmt.Errorf("跳过本次检测: %v", err)
+	}
+	// 只拦截新连接的网关不会影响已有连接，检测前关闭上一轮留下的连接
+	transport.closeIdle()
+	sess := newSession(config, transport)
+	defer sess.close()
+	defer func() { link.setFamilies(sess.familyStates()) }()
+	ctx = withSession(ctx, sess)
+	logCtx(ctx, DEBUG, "启动认证流程")
 
 	// 步骤1: 检测网络状态
-	result, params, err := checkNetworkStatus()
+	auth := portalAuthenticator(config)
+	result, params, err := auth.Detect(withPhase(ctx, config, PhaseDetect), config)
+	link.setRemaining(sess.remaining)
 	if err != nil {
 		return fmt.Errorf("网络检测失败: %v", err)
 	}
@@ -619,42 +1019,94 @@ func authProcess(config *Config) error {
 	// 情况处理
 	switch {
 	case result == "":
-		log(INFO, "当前无需认证")
+		logCtx(ctx, INFO, "当前无需认证")
 		return nil
 
 	case result == "NEED_AUTH":
-		log(INFO, "开始认证流程...")
-		if err := doAuth(config, params); err != nil {
+		auth = detectedAuthenticator(config, params)
+		checkLocalParams(ctx, config, params)
+
+		// 网络环境与配置的门户身份不符时不发送校园网账号，避免泄露给伪造的门户；
+		// WISPr 热点与通用表单使用各自的专用账号，不受校园网门户的校验约束
+		if sendsCampusCredentials(auth) {
+			if err := checkPortalTrust(ctx, config, params); err != nil {
+				logCtx(ctx, WARN, "门户身份校验未通过，拒绝发送账号密码: %v", err)
+				return fmt.Errorf("门户身份校验未通过: %v", err)
+			}
+		}
+		logCtx(ctx, INFO, "开始认证流程 (%s)...", auth.Describe())
+
+		authCtx := withPhase(ctx, config, PhaseAuth)
+		verifyCtx := withPhase(ctx, config, PhaseVerify)
+		login := func() error {
+			logout, err := auth.Login(authCtx, config, params)
+			if err != nil {
+				return err
+			}
+			if logout != "" {
+				link.setLogoutURL(logout)
+			}
+			// 认证前建立的连接可能仍被网关按未认证处理，验证使用新连接
+			transport.closeIdle()
+			return nil
+		}
+		if err := login(); err != nil {
 			return fmt.Errorf("认证失败: %v", err)
 		}
 
 		// 第一次验证
-		if ok, _ := verifyAuth(); ok {
-			log(INFO, "第一次验证成功，认证完成")
+		if ok, _ := auth.Verify(verifyCtx, config); ok {
+			logCtx(ctx, INFO, "第一次验证成功，认证完成")
+			link.authenticated()
 			return nil
 		}
 
-		log(WARN, "第一次验证失败，将尝试第二次认证")
+		logCtx(ctx, WARN, "第一次验证失败，将尝试第二次认证")
 
 		// 第二次尝试
-		if err := doAuth(config, params); err != nil {
+		if err := login(); err != nil {
 			return fmt.Errorf("第二次认证失败: %v", err)
 		}
 
 		// 第二次验证
-		if ok, _ := verifyAuth(); ok {
-			log(INFO, "第二次验证成功，认证完成")
+		if ok, _ := auth.Verify(verifyCtx, config); ok {
+			logCtx(ctx, INFO, "第二次验证成功，认证完成")
+			link.authenticated()
 			return nil
 		}
 
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return errors.New("两次认证尝试均失败")
 
 	default: // 已获取登出URL
-		log(DEBUG, "当前已认证")
+		logCtx(ctx, DEBUG, "当前已认证")
+		link.setLogoutURL(result)
 		return nil
 	}
 }
 
+// 退出前的收尾工作，各网卡并发登出，整体不超过宽限时间
+func shutdown(config *Config, links []*linkState) {
+	if !config.LogoutOnShutdown {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownGrace)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, link := range links {
+		wg.Add(1)
+		go func(link *linkState) {
+			defer wg.Done()
+			link.logout(ctx)
+		}(link)
+	}
+	wg.Wait()
+}
+
 func main() {
 
 	// 初始化日志系统
@@ -669,28 +1121,46 @@ func main() {
 		log(ERROR, "加载配置失败: %v", err)
 		os.Exit(1)
 	}
+	logProxyEnv()
 
-	// 设置信号处理
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	// 创建定时器
-	ticker := time.NewTicker(1 * time.Minute)
-	defer ticker.Stop()
+	// 设置信号处理，收到信号后取消进行中的请求
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	log(INFO, "程序启动，将每分钟运行一次认证流程")
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigChan
+		log(INFO, "收到信号 %v，开始退出", sig)
+		cancel()
 
-	// 主循环
-	for {
+		// 超过宽限时间或再次收到信号时强制退出
 		select {
-		case <-ticker.C:
-			log(DEBUG, "开始定时认证流程")
-			if err := authProcess(config); err != nil {
-				log(ERROR, "认证流程失败: %v", err)
-			}
-		case sig := <-sigChan:
-			log(INFO, "收到信号 %v，程序退出", sig)
-			return
+		case sig = <-sigChan:
+			log(WARN, "再次收到信号 %v，强制退出", sig)
+		case <-time.After(config.ShutdownGrace):
+			log(WARN, "退出超过宽限时间 %v，强制退出", config.ShutdownGrace)
 		}
+		os.Exit(1)
+	}()
+
+	log(INFO, "程序启动，门户类型: %s，立即检测后每分钟运行一次认证流程", portalAuthenticator(config).Describe())
+
+	// 每个网卡在独立的 goroutine 中检测与认证
+	links := newLinks(config)
+	watcher := startNetworkWatcher(ctx)
+	clock := startClockWatcher(ctx)
+	var wg sync.WaitGroup
+	for _, link := range links {
+		wg.Add(1)
+		go func(link *linkState, changes <-chan int, resumes <-chan struct{}) {
+			defer wg.Done()
+			link.run(ctx, changes, resumes, func() { reportStatus(config, links) })
+		}(link, watcher.subscribe(), clock.subscribe())
 	}
+
+	<-ctx.Done()
+	wg.Wait()
+	shutdown(config, links)
+	log(INFO, "程序退出")
 }
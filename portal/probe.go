@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 探测结果状态
+const (
+	ProbeFailed      = iota // 请求失败
+	ProbeOnline             // 返回预期内容，网络畅通
+	ProbeLoggedIn           // 被重定向到登出页面，已认证
+	ProbeNeedAuth           // 被重定向到认证页面，需要认证
+	ProbeOffCampus          // 不在校园网内
+	ProbeUnknown            // 无法识别的响应
+	ProbeIntercepted        // HTTPS 证书与域名不符，门户拦截了 HTTPS
+	ProbeReset              // 连接被重置，门户阻断了该端口
+)
+
+// 探测方式，对应配置项 probeMode，只影响默认探测列表
+const (
+	ProbeModeHTTP  = "http"  // 只使用 HTTP 探测
+	ProbeModeHTTPS = "https" // 只使用 HTTPS 探测，用于阻断 80 端口或只拦截 HTTPS 的网络
+	ProbeModeBoth  = "both"  // 同时使用 HTTP 与 HTTPS 探测
+)
+
+// Probe 连通性探测目标
+type Probe struct {
+	URL    string
+	Status int    // 预期状态码
+	Body   string // 预期响应体包含的文本，为空则不检查
+}
+
+// ProbeResult 单个探测目标的结果
+type ProbeResult struct {
+	Probe      Probe
+	State      int
+	StatusCode int
+	LogoutURL  string
+	Params     *AuthParams
+	Err        error
+	Elapsed    time.Duration
+	Hops       []probeHop // 依次访问过的地址
+}
+
+// probeHop 重定向链中的一跳
+type probeHop struct {
+	URL        string
+	StatusCode int
+}
+
+// 默认探测目标，分属不同的服务商
+var defaultProbes = []Probe{
+	{URL: CheckURL, Status: http.StatusNoContent},
+	{URL: VerifyURL, Status: http.StatusNoContent},
+	{URL: "http://captive.apple.com/hotspot-detect.html", Status: http.StatusOK, Body: "Success"},
+	{URL: "http://www.msftconnecttest.com/connecttest.txt", Status: http.StatusOK, Body: "Microsoft Connect Test"},
+}
+
+// 默认的 HTTPS 探测目标
+var defaultHTTPSProbes = []Probe{
+	{URL: "https://www.gstatic.com/generate_204", Status: http.StatusNoContent},
+	{URL: "https://cp.cloudflare.com/generate_204", Status: http.StatusNoContent},
+	{URL: "https://captive.apple.com/hotspot-detect.html", Status: http.StatusOK, Body: "Success"},
+}
+
+// 按探测方式获取默认探测列表
+func defaultProbeList(mode string) []Probe {
+	switch mode {
+	case ProbeModeHTTPS:
+		return defaultHTTPSProbes
+	case ProbeModeBoth:
+		return append(slices.Clone(defaultProbes), defaultHTTPSProbes...)
+	}
+	return defaultProbes
+}
+
+// 解析探测目标配置，格式: URL [预期状态码] [预期响应体文本]
+func parseProbe(value string) (Probe, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return Probe{}, errors.New("探测地址为空")
+	}
+
+	probe := Probe{URL: fields[0], Status: http.StatusNoContent}
+	if !strings.HasPrefix(probe.URL, "http://") && !strings.HasPrefix(probe.URL, "https://") {
+		return Probe{}, fmt.Errorf("探测地址必须以 http:// 或 https:// 开头: %s", probe.URL)
+	}
+	if len(fields) > 1 {
+		status, err := strconv.Atoi(fields[1])
+		if err != nil || status < 100 || status > 599 {
+			return Probe{}, fmt.Errorf("无效的预期状态码: %s", fields[1])
+		}
+		probe.Status = status
+	}
+	if len(fields) > 2 {
+		probe.Body = strings.Join(fields[2:], " ")
+	}
+	return probe, nil
+}
+
+// 获取探测法定数量，未配置或超过探测数量时取多数
+func probeQuorum(config *Config, total int) int {
+	if config.ProbeQuorum > 0 && config.ProbeQuorum <= total {
+		return config.ProbeQuorum
+	}
+	return total/2 + 1
+}
+
+// 获取探测状态名称
+func probeStateName(state int) string {
+	switch state {
+	case ProbeOnline:
+		return "在线"
+	case ProbeLoggedIn:
+		return "已认证"
+	case ProbeNeedAuth:
+		return "需要认证"
+	case ProbeOffCampus:
+		return "不在网络内"
+	case ProbeUnknown:
+		return "未识别"
+	case ProbeIntercepted:
+		return "HTTPS被拦截"
+	case ProbeReset:
+		return "连接被重置"
+	default:
+		return "失败"
+	}
+}
+
+// 并发执行所有探测，结果顺序与探测目标一致；限定地址族时只执行适用的探测
+func runProbes(ctx context.Context, config *Config) []*ProbeResult {
+	probes := probesFor(config, familyFrom(ctx))
+
+	client := httpClient(ctx, false)
+
+	results := make([]*ProbeResult, len(probes))
+	var wg sync.WaitGroup
+	for i, probe := range probes {
+		wg.Add(1)
+		go func(i int, probe Probe) {
+			defer wg.Done()
+			results[i] = probeOnce(ctx, client, config, probe)
+		}(i, probe)
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		if r.Err != nil {
+			logCtx(ctx, INFO, "探测 %s: %s (%v, 耗时 %v)", r.Probe.URL, probeStateName(r.State), r.Err, r.Elapsed.Round(time.Millisecond))
+			continue
+		}
+		if len(r.Hops) > 1 {
+			logCtx(ctx, INFO, "探测 %s: %s (状态码 %d, 经过 %d 跳, 耗时 %v)", r.Probe.URL, probeStateName(r.State), r.StatusCode, len(r.Hops), r.Elapsed.Round(time.Millisecond))
+			for i, hop := range r.Hops {
+				logCtx(ctx, DEBUG, "  第 %d 跳: %d %s", i+1, hop.StatusCode, hop.URL)
+			}
+			continue
+		}
+		logCtx(ctx, INFO, "探测 %s: %s (状态码 %d, 耗时 %v)", r.Probe.URL, probeStateName(r.State), r.StatusCode, r.Elapsed.Round(time.Millisecond))
+	}
+	return results
+}
+
+// 执行单个探测，未命中规则时自行跟随重定向，每一跳都按规则判断。
+// HTTPS 证书不符时不校验证书重新请求，以便从门户的响应中获取认证参数
+func probeOnce(ctx context.Context, client *http.Client, config *Config, probe Probe) *ProbeResult {
+	result := &ProbeResult{Probe: probe, State: ProbeFailed}
+	start := time.Now()
+	intercepted := false
+	defer func() {
+		result.Elapsed = time.Since(start)
+		// 证书不符时即使得到预期内容也不可信
+		if intercepted && (result.State == ProbeOnline || result.State == ProbeUnknown || result.State == ProbeFailed) {
+			result.State = ProbeIntercepted
+		}
+	}()
+
+	visited := make(map[string]bool)
+	target := probe.URL
+	for hop := 0; ; hop++ {
+		visited[target] = true
+
+		resp, body, err := fetchProbe(ctx, client, target)
+		if err != nil && !intercepted && isCertError(err) {
+			logCtx(ctx, WARN, "%s 的证书校验失败，疑似门户拦截了 HTTPS: %v", target, err)
+			intercepted = true
+			client = insecureClient(ctx)
+			resp, body, err = fetchProbe(ctx, client, target)
+		}
+		if err != nil {
+			result.State = ProbeFailed
+			if isConnReset(err) {
+				result.State = ProbeReset
+			}
+			result.Err = err
+			return result
+		}
+		result.StatusCode = resp.StatusCode
+		result.Hops = append(result.Hops, probeHop{URL: target, StatusCode: resp.StatusCode})
+
+		if config.WISPr && classifyWISPr(ctx, result, resp, body) {
+			return result
+		}
+		classifyResponse(ctx, result, config, resp, body)
+		if result.State != ProbeUnknown || result.Err != nil {
+			return result
+		}
+
+		// 只有探测地址本身返回预期内容才算在线
+		if hop == 0 && resp.StatusCode == probe.Status && strings.Contains(body, probe.Body) {
+			result.State = ProbeOnline
+			return result
+		}
+
+		next := nextHop(ctx, resp, body)
+		switch {
+		case next == "":
+			return result
+		case hop >= config.MaxRedirects:
+			logCtx(ctx, DEBUG, "%s 已达到最大跳转次数 %d，停止跟随", probe.URL, config.MaxRedirects)
+			return result
+		case visited[next]:
+			logCtx(ctx, WARN, "%s 的重定向出现循环: %s", probe.URL, next)
+			return result
+		case !followAllowed(config, resp.Request.URL, next):
+			logCtx(ctx, DEBUG, "%s 重定向到网络外的地址，停止跟随: %s", probe.URL, next)
+			return result
+		}
+		logCtx(ctx, DEBUG, "%s 第 %d 跳重定向到: %s", probe.URL, hop+1, next)
+		target = next
+	}
+}
+
+// 发送探测请求并读取响应体
+func fetchProbe(ctx context.Context, client *http.Client, target string) (*http.Response, string, error) {
+	logCtx(ctx, DEBUG, "发送请求到: %s", target)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("创建请求失败: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logCtx(ctx, ERROR, "关闭响应体失败: %v", err)
+		}
+	}()
+	logCtx(ctx, DEBUG, "%s 收到响应状态码: %d", target, resp.StatusCode)
+
+	body, err := readBody(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("读取响应失败: %v", err)
+	}
+	return resp, string(body), nil
+}
+
+// 判断是否为证书校验失败：证书不受信任、与域名不符或已过期
+func isCertError(err error) bool {
+	var verifyErr *tls.CertificateVerificationError
+	var hostErr x509.HostnameError
+	var authorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	return errors.As(err, &verifyErr) || errors.As(err, &hostErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &invalidErr)
+}
+
+// 判断连接是否在建立或 TLS 握手时被重置，之后的重置（如服务器关闭空闲连接）不算
+func isConnReset(err error) bool {
+	var dialErr *dialError
+	if !errors.As(err, &dialErr) {
+		return false
+	}
+	if errors.Is(dialErr, syscall.ECONNRESET) {
+		return true
+	}
+	// Windows 的错误码与 syscall.ECONNRESET 不同，按错误信息判断
+	msg := dialErr.Error()
+	return strings.Contains(msg, "connection reset") || strings.Contains(msg, "forcibly closed")
+}
+
+// 获取下一跳地址：3xx 取 Location，其他取页面中的脚本跳转
+func nextHop(ctx context.Context, resp *http.Response, body string) string {
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return resolveRedirect(resp.Header.Get("Location"), resp.Request.URL)
+	case http.StatusOK:
+		return extractRedirectURL(ctx, body, resp.Request.URL)
+	}
+	return ""
+}
+
+// 判断是否允许跟随到下一跳：同一主机、内网 IP 地址（门户控制器通常直接使用 IP）
+// 或配置的 followHost 域名，避免跟随到外网站点
+func followAllowed(config *Config, from *url.URL, next string) bool {
+	u, err := url.Parse(next)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == strings.ToLower(from.Hostname()) {
+		return true
+	}
+	for _, allowed := range config.FollowHosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	// 门户通常部署在内网地址上，公网 IP 与其他域名一样需要配置 followHost
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast()
+	}
+	return false
+}
+
+// 检测网络状态并获取认证信息
+func checkNetworkStatus(ctx context.Context, config *Config) (string, *AuthParams, error) {
+	logCtx(ctx, DEBUG, "开始检测网络状态")
+
+	// 优先使用 Captive Portal API；分地址族检测时 API 无法区分地址族，只采用需要认证的结果
+	if result, params, ok := checkCaptiveAPI(ctx, config); ok && (len(config.IPFamilies) == 0 || result == "NEED_AUTH") {
+		return result, params, nil
+	}
+	if ctx.Err() != nil {
+		return "", nil, ctx.Err()
+	}
+
+	hijacked := detectDNSHijack(ctx, config)
+	if len(config.IPFamilies) == 0 {
+		result, params, _, err := detectFamily(ctx, config, hijacked)
+		return result, params, err
+	}
+
+	// 各地址族并发检测，任一地址族需要认证即认证
+	type familyResult struct {
+		result string
+		params *AuthParams
+		state  string
+		err    error
+	}
+	results := make([]familyResult, len(config.IPFamilies))
+	var wg sync.WaitGroup
+	for i, family := range config.IPFamilies {
+		wg.Add(1)
+		go func(i int, family string) {
+			defer wg.Done()
+			r := &results[i]
+			r.result, r.params, r.state, r.err = detectFamily(withFamily(ctx, family), config, hijacked)
+		}(i, family)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return "", nil, ctx.Err()
+	}
+
+	sess := sessionFrom(ctx)
+	parts := make([]string, 0, len(results))
+	for i, family := range config.IPFamilies {
+		if sess != nil {
+			sess.setFamilyState(family, results[i].state)
+		}
+		parts = append(parts, familyLabel(family)+"="+results[i].state)
+	}
+	logCtx(ctx, INFO, "地址族状态: %s", strings.Join(parts, ", "))
+
+	var logout string
+	var errs []string
+	for i, family := range config.IPFamilies {
+		r := results[i]
+		switch {
+		case r.result == "NEED_AUTH":
+			logCtx(ctx, INFO, "%s 需要认证", familyLabel(family))
+			return r.result, r.params, nil
+		case r.err != nil:
+			errs = append(errs, familyLabel(family)+": "+r.err.Error())
+		case logout == "":
+			logout = r.result
+		}
+	}
+	if len(errs) > 0 {
+		return "", nil, errors.New(strings.Join(errs, "; "))
+	}
+	return logout, nil, nil
+}
+
+// 按当前 context 限定的地址族执行探测并汇总，返回结果与地址族状态；
+// 探测结果不足以判断时，HTTPS 被拦截，或已有探测被重定向且 DNS 被劫持，视为需要认证
+func detectFamily(ctx context.Context, config *Config, hijacked bool) (string, *AuthParams, string, error) {
+	results := runProbes(ctx, config)
+	if ctx.Err() != nil {
+		return "", nil, FamilyFailed, ctx.Err()
+	}
+	if len(results) == 0 {
+		return "", nil, FamilyFailed, errors.New("没有适用的探测目标")
+	}
+
+	quorum := probeQuorum(config, len(results))
+	counts := make(map[int]int)
+	var params *AuthParams
+	var logout string
+	for _, r := range results {
+		counts[r.State]++
+		if r.Params != nil && params == nil {
+			params = r.Params
+		}
+		if r.LogoutURL != "" && logout == "" {
+			logout = r.LogoutURL
+		}
+	}
+	online := counts[ProbeOnline] + counts[ProbeLoggedIn] + counts[ProbeOffCampus]
+	captive := counts[ProbeNeedAuth] + counts[ProbeIntercepted]
+	logCtx(ctx, DEBUG, "探测汇总: 需要认证 %d, HTTPS被拦截 %d, 连接被重置 %d, 在线 %d, 失败 %d, 法定数量 %d",
+		counts[ProbeNeedAuth], counts[ProbeIntercepted], counts[ProbeReset], online, counts[ProbeFailed], quorum)
+
+	// HTTPS 被拦截，或 DNS 被劫持且已有探测被重定向，在探测结果不足以判断时作为需要认证的依据；
+	// DNS 劫持也可能来自校园网的 DNS 策略，不能单独作为依据
+	signal := ""
+	switch {
+	case counts[ProbeIntercepted] > 0:
+		signal = "HTTPS 被拦截"
+	case hijacked && captive > 0:
+		signal = "DNS 被劫持"
+	case hijacked:
+		logCtx(ctx, DEBUG, "DNS 疑似被劫持，但没有探测被重定向，不作为需要认证的依据")
+	}
+
+	switch {
+	case captive >= quorum && params != nil:
+		logCtx(ctx, INFO, "%d 个探测被重定向到认证页面或 HTTPS 被拦截，需要认证", captive)
+		return "NEED_AUTH", params, FamilyCaptive, nil
+
+	case captive >= quorum:
+		logCtx(ctx, WARN, "HTTPS 被拦截，疑似需要认证，但探测没有获取到认证参数")
+		return "", nil, FamilyCaptive, errors.New("HTTPS 被拦截但没有获取到认证参数")
+
+	case online >= quorum:
+		if logout != "" {
+			logCtx(ctx, INFO, "当前已认证，无需认证，登出链接: %s", logout)
+			return logout, nil, FamilyOnline, nil
+		}
+		if counts[ProbeOffCampus] > 0 {
+			logCtx(ctx, INFO, "探测命中不在网络内规则，疑似不在网络内")
+			return "", nil, FamilyFailed, errors.New("疑似不在网络内")
+		}
+		logCtx(ctx, INFO, "%d 个探测返回预期内容，网络畅通", online)
+		return "", nil, FamilyOnline, nil
+
+	case signal != "" && params != nil:
+		logCtx(ctx, INFO, "探测结果未达到法定数量，但%s，需要认证", signal)
+		return "NEED_AUTH", params, FamilyCaptive, nil
+
+	case signal != "":
+		logCtx(ctx, WARN, "%s，疑似需要认证，但探测没有获取到认证参数", signal)
+		return "", nil, FamilyCaptive, fmt.Errorf("%s但没有获取到认证参数", signal)
+
+	case counts[ProbeFailed]+counts[ProbeReset] == len(results):
+		logCtx(ctx, INFO, "所有探测均失败，可能不在网络内")
+		return "", nil, FamilyFailed, errors.New("所有探测均失败，可能不在网络内")
+
+	// 网络不稳定时也会偶尔被重置，达到法定数量才作为需要认证的依据
+	case counts[ProbeReset] >= quorum && params != nil:
+		logCtx(ctx, INFO, "%d 个探测的连接被重置，需要认证", counts[ProbeReset])
+		return "NEED_AUTH", params, FamilyCaptive, nil
+
+	case counts[ProbeReset] >= quorum:
+		logCtx(ctx, WARN, "%d 个探测的连接被重置，疑似需要认证，但探测没有获取到认证参数", counts[ProbeReset])
+		return "", nil, FamilyCaptive, errors.New("连接被重置但没有获取到认证参数")
+	}
+
+	logCtx(ctx, WARN, "探测结果未达到法定数量 %d，本次不做处理", quorum)
+	return "", nil, FamilyUnknown, nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFollowAllowed(t *testing.T) {
+	from, _ := url.Parse("http://1.1.1.1/")
+	tests := []struct {
+		name   string
+		follow []string
+		next   string
+		want   bool
+	}{
+		{"同一主机", nil, "http://1.1.1.1/portal.do", true},
+		{"内网 IP", nil, "http://10.20.16.5/portalScript.do", true},
+		{"链路本地 IP", nil, "http://169.254.1.1/", true},
+		{"公网 IP 未配置", nil, "http://1.1.1.2/", false},
+		{"公网 IP 已配置", []string{"1.1.1.2"}, "http://1.1.1.2/", true},
+		{"其他公网 IP", []string{"1.1.1.2"}, "http://1.1.1.3/", false},
+		{"域名未配置", nil, "http://portal.example.edu.cn/", false},
+		{"子域名", []string{"example.edu.cn"}, "http://Portal.Example.edu.cn/login", true},
+		{"后缀相同的其他域名", []string{"example.edu.cn"}, "http://badexample.edu.cn/", false},
+		{"无效地址", nil, "http://[::1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{FollowHosts: tt.follow}
+			if got := followAllowed(config, from, tt.next); got != tt.want {
+				t.Errorf("followAllowed(%q) = %v, want %v", tt.next, got, tt.want)
+			}
+		})
+	}
+}
+
+// 使用 GGS 内置规则与指定探测目标的配置
+func testProbeConfig(urls ...string) *Config {
+	config := &Config{PortalType: PortalGGS, Rules: builtinRules(PortalGGS), MaxRedirects: MaxRedirects}
+	for _, u := range urls {
+		config.Probes = append(config.Probes, Probe{URL: u, Status: http.StatusNoContent})
+	}
+	return config
+}
+
+// 返回一个已关闭的地址，连接被拒绝
+func closedURL(t *testing.T) string {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	return srv.URL + "/generate_204"
+}
+
+func TestDetectFamilyDNSHijack(t *testing.T) {
+	portal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, ggsPortalPage)
+	}))
+	defer portal.Close()
+	redirected, failed := portal.URL+"/generate_204", closedURL(t)
+
+	tests := []struct {
+		name     string
+		probes   []string
+		hijacked bool
+		want     string
+	}{
+		{"DNS 被劫持且有探测被重定向", []string{redirected, failed, failed}, true, "NEED_AUTH"},
+		{"只有 DNS 被劫持", []string{failed, failed, failed}, true, ""},
+		{"有探测被重定向但未达到法定数量", []string{redirected, failed, failed}, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, _, state, _ := detectFamily(context.Background(), testProbeConfig(tt.probes...), tt.hijacked)
+			if result != tt.want {
+				t.Errorf("detectFamily() = %q (%s), want %q", result, state, tt.want)
+			}
+			if tt.want == "" && state == FamilyCaptive {
+				t.Errorf("detectFamily() 状态为 %s", state)
+			}
+		})
+	}
+}
+
+// resetServer 读到客户端的第一段数据后以 RST 关闭连接：
+// 对 HTTPS 是在 TLS 握手时被重置，对 HTTP 是在发出请求后被重置
+func resetServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				conn.Read(make([]byte, 4096))
+				conn.(*net.TCPConn).SetLinger(0)
+				conn.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestProbeOnceReset(t *testing.T) {
+	addr := resetServer(t)
+	tests := []struct {
+		name  string
+		url   string
+		state int
+	}{
+		{"TLS 握手时被重置", "https://" + addr + "/generate_204", ProbeReset},
+		{"发出请求后被重置", "http://" + addr + "/generate_204", ProbeFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := withPhase(context.Background(), &Config{}, PhaseDetect)
+			config := testProbeConfig(tt.url)
+			result := probeOnce(ctx, httpClient(ctx, false), config, config.Probes[0])
+			if result.State != tt.state {
+				t.Errorf("State = %s (%v), want %s", probeStateName(result.State), result.Err, probeStateName(tt.state))
+			}
+		})
+	}
+}
+
+func TestProbeOnceIntercepted(t *testing.T) {
+	// 自签名证书的服务器返回了预期内容，仍视为 HTTPS 被拦截
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	ctx := withPhase(context.Background(), &Config{}, PhaseDetect)
+	config := testProbeConfig(srv.URL + "/generate_204")
+	result := probeOnce(ctx, httpClient(ctx, false), config, config.Probes[0])
+	if result.State != ProbeIntercepted {
+		t.Errorf("State = %s (%v), want %s", probeStateName(result.State), result.Err, probeStateName(ProbeIntercepted))
+	}
+}
+
+func TestDetectFamilyResetQuorum(t *testing.T) {
+	reset := "https://" + resetServer(t) + "/generate_204"
+	failed := closedURL(t)
+	unknown := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<html>blocked</html>")
+	}))
+	defer unknown.Close()
+
+	tests := []struct {
+		name  string
+		urls  []string
+		state string
+	}{
+		{"单个重置与失败", []string{reset, failed, failed}, FamilyFailed},
+		{"单个重置未达到法定数量", []string{reset, unknown.URL, unknown.URL}, FamilyUnknown},
+		{"重置达到法定数量", []string{reset, reset, unknown.URL}, FamilyCaptive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, _, state, _ := detectFamily(context.Background(), testProbeConfig(tt.urls...), false)
+			if result == "NEED_AUTH" || state != tt.state {
+				t.Errorf("detectFamily() = %q, %s, want %s", result, state, tt.state)
+			}
+		})
+	}
+}
+
+func TestIsConnReset(t *testing.T) {
+	wrap := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://www.gstatic.com/generate_204", Err: err}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"建立连接时被重置", wrap(&dialError{err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}}), true},
+		{"Windows 错误信息", wrap(&dialError{err: errors.New("wsarecv: An existing connection was forcibly closed by the remote host.")}), true},
+		{"发出请求后被重置", wrap(&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}), false},
+		{"连接被拒绝", wrap(&dialError{err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}), false},
+		{"连接被关闭", wrap(io.EOF), false},
+	}
+	for _, tt := range tests {
+		if got := isConnReset(tt.err); got != tt.want {
+			t.Errorf("%s: isConnReset() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsCertError(t *testing.T) {
+	wrap := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://www.gstatic.com/generate_204", Err: &dialError{err: err}}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"未知的签发者", wrap(x509.UnknownAuthorityError{}), true},
+		{"域名不符", wrap(x509.HostnameError{Certificate: &x509.Certificate{}, Host: "www.gstatic.com"}), true},
+		{"证书过期", wrap(x509.CertificateInvalidError{Reason: x509.Expired}), true},
+		{"连接被重置", wrap(syscall.ECONNRESET), false},
+		{"超时", wrap(context.DeadlineExceeded), false},
+	}
+	for _, tt := range tests {
+		if got := isCertError(tt.err); got != tt.want {
+			t.Errorf("%s: isCertError() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// 使用环境变量 HTTP_PROXY、HTTPS_PROXY、NO_PROXY 中的代理
+const ProxyEnv = "env"
+
+// 门户流量默认忽略的代理环境变量
+var proxyEnvVars = []string{"HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY", "http_proxy", "https_proxy", "all_proxy"}
+
+type proxyKey struct{}
+
+// 解析代理配置：env 或 http://、https://、socks5:// 地址
+func parseProxy(value string) (string, error) {
+	if strings.EqualFold(value, ProxyEnv) {
+		return ProxyEnv, nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("无效的代理地址: %s", value)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "socks5":
+	default:
+		return "", fmt.Errorf("不支持的代理类型: %s", u.Scheme)
+	}
+	return value, nil
+}
+
+// 将代理附加到 context，其中的请求经该代理发出，为空时直连
+func withProxy(ctx context.Context, proxy string) context.Context {
+	return context.WithValue(ctx, proxyKey{}, proxy)
+}
+
+// 获取当前请求使用的代理，未指定时返回空
+func proxyFrom(ctx context.Context) string {
+	proxy, _ := ctx.Value(proxyKey{}).(string)
+	return proxy
+}
+
+// 代理配置对应的 http.Transport.Proxy
+func proxyFunc(proxy string) func(*http.Request) (*url.URL, error) {
+	switch proxy {
+	case "":
+		return nil
+	case ProxyEnv:
+		return http.ProxyFromEnvironment
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil
+	}
+	return http.ProxyURL(u)
+}
+
+// 描述请求经过的路线，用于日志
+func describeRoute(base http.RoundTripper, req *http.Request) string {
+	t, ok := base.(*http.Transport)
+	if !ok || t.Proxy == nil {
+		return "(直连)"
+	}
+	u, err := t.Proxy(req)
+	if err != nil {
+		return fmt.Sprintf("(代理错误: %v)", err)
+	}
+	if u == nil {
+		return "(直连)"
+	}
+	return "(经代理 " + u.Redacted() + ")"
+}
+
+// 隐藏代理地址中的密码，用于日志
+func redactProxy(proxy string) string {
+	if u, err := url.Parse(proxy); err == nil && proxy != ProxyEnv {
+		return u.Redacted()
+	}
+	return proxy
+}
+
+// 系统配置了代理环境变量时提示门户流量将直连
+func logProxyEnv() {
+	for _, name := range proxyEnvVars {
+		if os.Getenv(name) != "" {
+			log(INFO, "检测到代理环境变量 %s，探测与认证请求不使用代理", name)
+			return
+		}
+	}
+}
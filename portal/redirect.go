@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 重定向候选的来源，数值越大越可信
+const (
+	RedirectBareURL = 10 // 页面中出现的门户链接
+	RedirectMeta    = 20 // <meta http-equiv="refresh">
+	RedirectScript  = 30 // location.replace / location.href 等脚本跳转
+)
+
+// redirectCandidate 从页面中提取到的重定向地址
+type redirectCandidate struct {
+	URL   string
+	Kind  int
+	Score int
+}
+
+var (
+	// location.replace(...) / location.assign(...)
+	jsCallPattern = regexp.MustCompile(`\blocation\s*\.\s*(?:replace|assign)\s*\(\s*`)
+	// window.location.href = ... / top.location = ... / location.href = ...
+	jsAssignPattern = regexp.MustCompile(`\blocation(?:\s*\.\s*href)?\s*=`)
+	// 页面中的门户链接
+	bareURLPattern = regexp.MustCompile(`https?://[^\s"'<>()]+`)
+	// meta refresh 中的 url=...
+	metaURLPattern = regexp.MustCompile(`(?i)url\s*=\s*['"]?([^'"]+)`)
+)
+
+// 从HTML/JavaScript内容中提取最可信的重定向URL
+func extractRedirectURL(ctx context.Context, content string, base *url.URL) string {
+	candidates := extractRedirectCandidates(ctx, content, base)
+	if len(candidates) == 0 {
+		return ""
+	}
+	for i, c := range candidates {
+		logCtx(ctx, DEBUG, "重定向候选 %d: %s (得分 %d)", i+1, c.URL, c.Score)
+	}
+	return candidates[0].URL
+}
+
+// 提取页面中所有可能的重定向地址，按可信度从高到低排列
+func extractRedirectCandidates(ctx context.Context, content string, base *url.URL) []redirectCandidate {
+	var candidates []redirectCandidate
+	seen := make(map[string]bool)
+	// partial 表示地址可能不完整（拼接了无法计算的变量，或直接取自页面），
+	// 此时以空参数值结尾的地址缺少门户参数（如 wlanuserip=），不能使用
+	add := func(raw string, kind int, partial bool) {
+		u := resolveRedirect(raw, base)
+		if u == "" || seen[u] {
+			return
+		}
+		if partial && strings.HasSuffix(u, "=") {
+			logCtx(ctx, DEBUG, "忽略缺少参数值的重定向候选: %s", u)
+			return
+		}
+		seen[u] = true
+		candidates = append(candidates, redirectCandidate{URL: u, Kind: kind, Score: kind + redirectBonus(u)})
+	}
+
+	// 1. 脚本跳转
+	for _, re := range []*regexp.Regexp{jsCallPattern, jsAssignPattern} {
+		for _, loc := range re.FindAllStringIndex(content, -1) {
+			// 赋值模式排除比较运算 location == ...
+			if re == jsAssignPattern && strings.HasPrefix(content[loc[1]:], "=") {
+				continue
+			}
+			if value, complete, ok := evalJSString(content[loc[1]:]); ok {
+				add(value, RedirectScript, !complete)
+			}
+		}
+	}
+
+	// 2. meta refresh
+	for _, tag := range scanTags(content) {
+		if tag.Name != "meta" || !strings.EqualFold(tag.Attrs["http-equiv"], "refresh") {
+			continue
+		}
+		if m := metaURLPattern.FindStringSubmatch(tag.Attrs["content"]); m != nil {
+			add(strings.TrimSpace(m[1]), RedirectMeta, false)
+		}
+	}
+
+	// 3. 页面中直接出现的门户链接
+	for _, raw := range bareURLPattern.FindAllString(content, -1) {
+		if strings.Contains(strings.ToLower(raw), "portal") {
+			add(raw, RedirectBareURL, true)
+		}
+	}
+
+	// 稳定排序，同分时保持出现顺序
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	return candidates
+}
+
+// 带有认证参数的地址更可能指向门户
+func redirectBonus(u string) int {
+	bonus := 0
+	lower := strings.ToLower(u)
+	if strings.Contains(lower, "portal") {
+		bonus += 3
+	}
+	if strings.Contains(lower, "wlanuserip=") || strings.Contains(lower, "mac=") {
+		bonus += 5
+	}
+	return bonus
+}
+
+// 解码实体并相对 base 解析为绝对地址
+func resolveRedirect(raw string, base *url.URL) string {
+	raw = strings.TrimSpace(html.UnescapeString(raw))
+	if raw == "" || strings.HasPrefix(raw, "#") || strings.HasPrefix(strings.ToLower(raw), "javascript:") {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	if !u.IsAbs() {
+		if base == nil {
+			return ""
+		}
+		u = base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return u.String()
+}
+
+// 计算由字符串字面量与 encodeURIComponent 调用用 '+' 拼接的表达式，
+// 遇到无法计算的部分（如变量）时返回已拼接的前缀，complete 为假
+func evalJSString(expr string) (value string, complete bool, ok bool) {
+	var sb strings.Builder
+	i := 0
+	terms := 0
+	complete = true
+	for {
+		i = skipJSSpace(expr, i)
+		if i >= len(expr) {
+			break
+		}
+
+		var term string
+		var n int
+		var parsed bool
+		switch {
+		case expr[i] == '"' || expr[i] == '\'':
+			term, n, parsed = parseJSStringLiteral(expr[i:])
+		case strings.HasPrefix(expr[i:], "encodeURIComponent("):
+			term, n, parsed = parseJSCall(expr[i:], "encodeURIComponent(", jsEncodeURIComponent)
+		}
+		if !parsed {
+			// '+' 之后无法计算
+			complete = terms == 0
+			break
+		}
+		sb.WriteString(term)
+		terms++
+		i = skipJSSpace(expr, i+n)
+		if i >= len(expr) || expr[i] != '+' {
+			break
+		}
+		i++
+	}
+	return sb.String(), complete, terms > 0
+}
+
+// 解析形如 fn("literal") 的调用
+func parseJSCall(s, prefix string, fn func(string) string) (string, int, bool) {
+	i := skipJSSpace(s, len(prefix))
+	if i >= len(s) || (s[i] != '"' && s[i] != '\'') {
+		return "", 0, false
+	}
+	value, n, ok := parseJSStringLiteral(s[i:])
+	if !ok {
+		return "", 0, false
+	}
+	i = skipJSSpace(s, i+n)
+	if i >= len(s) || s[i] != ')' {
+		return "", 0, false
+	}
+	return fn(value), i + 1, true
+}
+
+// 解析以引号开头的 JavaScript 字符串字面量，返回值与消耗的字节数
+func parseJSStringLiteral(s string) (string, int, bool) {
+	quote := s[0]
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == quote:
+			return sb.String(), i + 1, true
+		case c == '\n':
+			return "", 0, false
+		case c != '\\':
+			sb.WriteByte(c)
+			continue
+		}
+
+		// 转义序列
+		i++
+		if i >= len(s) {
+			return "", 0, false
+		}
+		switch s[i] {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case 'x':
+			if i+2 < len(s) {
+				if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+					sb.WriteByte(byte(v))
+					i += 2
+					continue
+				}
+			}
+			sb.WriteByte('x')
+		case 'u':
+			if i+4 < len(s) {
+				if v, err := strconv.ParseUint(s[i+1:i+5], 16, 16); err == nil {
+					sb.WriteRune(rune(v))
+					i += 4
+					continue
+				}
+			}
+			sb.WriteByte('u')
+		default:
+			// \/ \" \' \\ 等直接取字符本身
+			sb.WriteByte(s[i])
+		}
+	}
+	return "", 0, false
+}
+
+func skipJSSpace(s string, i int) int {
+	for i < len(s) && isSpace(s[i]) {
+		i++
+	}
+	return i
+}
+
+// 与浏览器 encodeURIComponent 一致的编码
+func jsEncodeURIComponent(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if r < utf8.RuneSelf && isURIUnreserved(byte(r)) {
+			sb.WriteRune(r)
+			continue
+		}
+		buf := make([]byte, utf8.RuneLen(r))
+		utf8.EncodeRune(buf, r)
+		for _, b := range buf {
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+func isURIUnreserved(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.IndexByte("-_.!~*'()", c) >= 0
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"testing"
+)
+
+// 门户页面取自 portal_go.md 中记录的 GGS 控制器响应，其余写法在该页面的基础上改写
+const ggsPortalPage = `<html>
+<head><script>
+location.replace("http://1.1.1.2/portal.do?wlanuserip=3.3.3.3&wlanacname=NFV-BASE-02&mac=11:a1:11:22:22:33&vlan=1111&hostname=&rand=52wsf&url="+encodeURIComponent("http://1.1.1.1"));
+</script></head>
+<body></body>
+</html>`
+
+// 上述页面中门户地址的查询参数
+const ggsPortalQuery = "wlanuserip=3.3.3.3&wlanacname=NFV-BASE-02&mac=11:a1:11:22:22:33&vlan=1111&hostname=&rand=52wsf"
+
+func TestExtractRedirectURL(t *testing.T) {
+	base, _ := url.Parse("http://1.1.1.1/")
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "location.replace 与 encodeURIComponent 拼接",
+			content: ggsPortalPage,
+			want:    "http://1.1.1.2/portal.do?" + ggsPortalQuery + "&url=http%3A%2F%2F1.1.1.1",
+		},
+		{
+			name:    "location.href 单引号",
+			content: `<html><head><script>top.self.location.href='http://1.1.1.2/portal.do?` + ggsPortalQuery + `'</script></head></html>`,
+			want:    "http://1.1.1.2/portal.do?" + ggsPortalQuery,
+		},
+		{
+			name:    "window.location 地址前有空格",
+			content: `<script>window.location=" http://1.1.1.2/login.jsp?` + ggsPortalQuery + `";</script>`,
+			want:    "http://1.1.1.2/login.jsp?" + ggsPortalQuery,
+		},
+		{
+			name:    "location 比较不是跳转",
+			content: `<script>if (location == "http://1.1.1.2/login.jsp?wlanuserip=3.3.3.3") {}</script>`,
+			want:    "",
+		},
+		{
+			name:    "meta refresh 与 HTML 实体",
+			content: `<html><head><meta http-equiv="Refresh" content="0;URL=http://1.1.1.2/portal.do?wlanuserip=3.3.3.3&amp;wlanacname=NFV-BASE-02&amp;mac=11:a1:11:22:22:33"></head></html>`,
+			want:    "http://1.1.1.2/portal.do?wlanuserip=3.3.3.3&wlanacname=NFV-BASE-02&mac=11:a1:11:22:22:33",
+		},
+		{
+			name:    "相对地址",
+			content: `<script>location.replace("/portal.do?` + ggsPortalQuery + `")</script>`,
+			want:    "http://1.1.1.1/portal.do?" + ggsPortalQuery,
+		},
+		{
+			name:    "转义字符",
+			content: `<script>location.href="http:\/\/1.1.1.2\/portal.do?wlanuserip\x3d3.3.3.3"</script>`,
+			want:    "http://1.1.1.2/portal.do?wlanuserip=3.3.3.3",
+		},
+		{
+			name:    "拼接变量后缺少参数值时不使用",
+			content: `<script>location.href = "http://1.1.1.2/portal.do?wlanuserip=" + userip;</script>`,
+			want:    "",
+		},
+		{
+			name: "脚本跳转优先于页面链接",
+			content: `<a href="http://1.1.1.2/portal/help.html">帮助</a>
+` + ggsPortalPage,
+			want: "http://1.1.1.2/portal.do?" + ggsPortalQuery + "&url=http%3A%2F%2F1.1.1.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractRedirectURL(context.Background(), tt.content, base); got != tt.want {
+				t.Errorf("extractRedirectURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
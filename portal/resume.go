@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 休眠唤醒检测参数
+const (
+	ClockCheckInterval = 5 * time.Second  // 比较墙上时间与单调时钟的间隔
+	ClockJumpThreshold = 30 * time.Second // 两者相差超过该值视为休眠唤醒或时钟跳变
+)
+
+// 启动及休眠唤醒后的快速重试间隔，检测成功或用完后恢复正常间隔
+var FastRetrySchedule = []time.Duration{5 * time.Second, 10 * time.Second, 15 * time.Second, 30 * time.Second}
+
+// clockWatcher 检测系统休眠唤醒与墙上时间跳变，并通知各网卡的认证循环
+type clockWatcher struct {
+	mu   sync.Mutex
+	subs []chan struct{}
+}
+
+// 开始检测，直到 ctx 取消
+func startClockWatcher(ctx context.Context) *clockWatcher {
+	w := &clockWatcher{}
+	go w.run(ctx)
+	return w
+}
+
+// 订阅唤醒事件
+func (w *clockWatcher) subscribe() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subs = append(w.subs, ch)
+	return ch
+}
+
+// 休眠期间单调时钟可能停止，而墙上时间照常前进；两次检查的间隔远超预期同样视为唤醒
+func (w *clockWatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(ClockCheckInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			mono := now.Sub(last)                   // 单调时钟经过的时间
+			wall := now.Round(0).Sub(last.Round(0)) // 墙上时间经过的时间
+			last = now
+
+			drift := wall - mono
+			if drift < 0 {
+				drift = -drift
+			}
+			if mono > ClockCheckInterval+ClockJumpThreshold || drift > ClockJumpThreshold {
+				log(INFO, "检测到系统休眠唤醒或时钟跳变 (墙上时间经过 %v, 单调时钟经过 %v)",
+					wall.Round(time.Second), mono.Round(time.Second))
+				w.notify()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *clockWatcher) notify() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, ch := range w.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Ruijie ePortal 接口路径
+const ruijieInterfacePath = "/eportal/InterFace.do"
+
+// Ruijie 控制器的内置检测规则，未认证时重定向到 /eportal/index.jsp
+var ruijieRules = []DetectRule{
+	{
+		Name:    "ruijie-page",
+		Status:  http.StatusOK,
+		Body:    `/eportal/index\.jsp`,
+		Action:  ActionNeedAuth,
+		Extract: ExtractRedirect,
+	},
+	{
+		Name:     "ruijie-redirect",
+		Status:   http.StatusFound,
+		Location: `/eportal/index\.jsp`,
+		Action:   ActionNeedAuth,
+		Extract:  ExtractLocation,
+	},
+}
+
+// ruijieResponse InterFace.do 的 JSON 响应
+type ruijieResponse struct {
+	UserIndex string `json:"userIndex"`
+	Result    string `json:"result"`
+	Message   string `json:"message"`
+}
+
+// 根据门户页面地址构造 InterFace.do 接口地址
+func ruijieEndpoint(pageURL, method string) (string, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("无效的门户地址: %s", pageURL)
+	}
+	endpoint := &url.URL{
+		Scheme:   u.Scheme,
+		Host:     u.Host,
+		Path:     ruijieInterfacePath,
+		RawQuery: "method=" + method,
+	}
+	return endpoint.String(), nil
+}
+
+// 执行 Ruijie 认证，成功时返回带 userIndex 的登出地址
+func ruijieLogin(ctx context.Context, config *Config, params *AuthParams) (string, error) {
+	logCtx(ctx, INFO, "开始执行Ruijie认证")
+
+	u, err := url.Parse(params.RedirectURL)
+	if err != nil {
+		return "", fmt.Errorf("解析门户地址失败: %v", err)
+	}
+	if u.RawQuery == "" {
+		return "", errors.New("门户地址中没有queryString")
+	}
+	endpoint, err := ruijieEndpoint(params.RedirectURL, "login")
+	if err != nil {
+		return "", err
+	}
+	if err := checkCredentialTarget(ctx, config, endpoint); err != nil {
+		return "", err
+	}
+
+	// queryString 需要在表单编码之前先编码一次，与门户页面脚本一致
+	form := url.Values{
+		"userId":          {config.UserID},
+		"password":        {config.Passwd},
+		"service":         {config.RuijieService},
+		"queryString":     {url.QueryEscape(u.RawQuery)},
+		"operatorPwd":     {""},
+		"operatorUserId":  {""},
+		"validcode":       {""},
+		"passwordEncrypt": {"false"},
+	}
+	logCtx(ctx, DEBUG, "发送Ruijie认证请求到: %s", endpoint)
+	reply, err := ruijieCall(ctx, endpoint, form)
+	if err != nil {
+		return "", err
+	}
+	logCtx(ctx, INFO, "Ruijie认证响应: result=%s, message=%s", reply.Result, reply.Message)
+
+	if reply.Result != "success" {
+		return "", fmt.Errorf("Ruijie认证失败: %s", reply.Message)
+	}
+	if reply.UserIndex == "" {
+		logCtx(ctx, WARN, "Ruijie认证成功但响应中没有userIndex，无法登出")
+		return "", nil
+	}
+
+	logout, err := ruijieEndpoint(params.RedirectURL, "logout")
+	if err != nil {
+		return "", err
+	}
+	return logout + "&userIndex=" + url.QueryEscape(reply.UserIndex), nil
+}
+
+// 判断登出地址是否为 Ruijie 接口
+func isRuijieLogout(logout string) bool {
+	return strings.Contains(logout, ruijieInterfacePath+"?method=logout")
+}
+
+// 执行 Ruijie 登出
+func ruijieLogout(ctx context.Context, logout string) error {
+	logCtx(ctx, INFO, "开始执行Ruijie登出")
+
+	u, err := url.Parse(logout)
+	if err != nil {
+		return fmt.Errorf("解析登出地址失败: %v", err)
+	}
+	form := url.Values{"userIndex": {u.Query().Get("userIndex")}}
+	u.RawQuery = "method=logout"
+
+	reply, err := ruijieCall(ctx, u.String(), form)
+	if err != nil {
+		return err
+	}
+	logCtx(ctx, INFO, "Ruijie登出响应: result=%s, message=%s", reply.Result, reply.Message)
+	if reply.Result != "success" {
+		return fmt.Errorf("Ruijie登出失败: %s", reply.Message)
+	}
+	return nil
+}
+
+// 以表单方式调用 InterFace.do 并解析 JSON 响应
+func ruijieCall(ctx context.Context, endpoint string, form url.Values) (*ruijieResponse, error) {
+	client := httpClient(ctx, true)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		logCtx(ctx, ERROR, "Ruijie请求失败: %v", err)
+		return nil, fmt.Errorf("Ruijie请求失败: %v", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logCtx(ctx, ERROR, "关闭响应体失败: %v", err)
+		}
+	}()
+	logCtx(ctx, DEBUG, "收到Ruijie响应状态码: %d", resp.StatusCode)
+
+	body, err := readBody(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %v", err)
+	}
+	reply := &ruijieResponse{}
+	if err := json.Unmarshal(body, reply); err != nil {
+		return nil, fmt.Errorf("解析Ruijie响应失败: %v (状态码: %d)", err, resp.StatusCode)
+	}
+	return reply, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// fakeRuijie 模拟 ePortal 的 InterFace.do 接口
+type fakeRuijie struct {
+	t         *testing.T
+	loginBody string     // 登录请求的原始表单
+	login     url.Values // 登录请求的表单
+	logout    url.Values // 登出请求的表单
+}
+
+func (f *fakeRuijie) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != ruijieInterfacePath || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	raw, _ := io.ReadAll(r.Body)
+	form, err := url.ParseQuery(string(raw))
+	if err != nil {
+		f.t.Errorf("解析表单失败: %v", err)
+	}
+
+	reply := ruijieResponse{Result: "success"}
+	switch r.URL.Query().Get("method") {
+	case "login":
+		f.loginBody, f.login = string(raw), form
+		if form.Get("password") != "secret" {
+			reply = ruijieResponse{Result: "fail", Message: "密码错误"}
+			break
+		}
+		reply.UserIndex = "3f2a1b_10.1.2.3_abc"
+		reply.Message = "认证成功"
+	case "logout":
+		f.logout = form
+		if form.Get("userIndex") != "3f2a1b_10.1.2.3_abc" {
+			reply = ruijieResponse{Result: "fail", Message: "用户不存在"}
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	json.NewEncoder(w).Encode(reply)
+}
+
+func TestRuijieLoginLogout(t *testing.T) {
+	fake := &fakeRuijie{t: t}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx := context.Background()
+	config := &Config{UserID: "2021001", Passwd: "secret", RuijieService: "校园网"}
+	query := "wlanuserip=10.1.2.3&wlanacname=RJ-AC&ssid=&nasip=10.0.0.1&mac=aabbccddeeff&url=http%3A%2F%2F1.1.1.1%2F"
+	params := &AuthParams{RedirectURL: srv.URL + "/eportal/index.jsp?" + query}
+
+	logout, err := ruijieLogin(ctx, config, params)
+	if err != nil {
+		t.Fatalf("ruijieLogin() error: %v", err)
+	}
+
+	// queryString 在表单编码前先编码一次，原始表单中 '=' 为 %253D，'%' 为 %2525
+	if got := fake.login.Get("queryString"); got != url.QueryEscape(query) {
+		t.Errorf("queryString = %q, want %q", got, url.QueryEscape(query))
+	}
+	if !strings.Contains(fake.loginBody, "queryString=wlanuserip%253D10.1.2.3%2526wlanacname%253DRJ-AC") ||
+		!strings.Contains(fake.loginBody, "url%253Dhttp%25253A%25252F%25252F1.1.1.1%25252F") {
+		t.Errorf("queryString 没有双重编码: %s", fake.loginBody)
+	}
+	for key, want := range map[string]string{"userId": "2021001", "service": "校园网", "passwordEncrypt": "false"} {
+		if got := fake.login.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+
+	wantLogout := srv.URL + ruijieInterfacePath + "?method=logout&userIndex=3f2a1b_10.1.2.3_abc"
+	if logout != wantLogout {
+		t.Errorf("logout = %q, want %q", logout, wantLogout)
+	}
+	if !isRuijieLogout(logout) {
+		t.Errorf("isRuijieLogout(%q) = false", logout)
+	}
+	if err := ruijieLogout(ctx, logout); err != nil {
+		t.Fatalf("ruijieLogout() error: %v", err)
+	}
+	if got := fake.logout.Get("userIndex"); got != "3f2a1b_10.1.2.3_abc" {
+		t.Errorf("登出 userIndex = %q", got)
+	}
+}
+
+func TestRuijieLoginRejected(t *testing.T) {
+	srv := httptest.NewServer(&fakeRuijie{t: t})
+	defer srv.Close()
+
+	config := &Config{UserID: "2021001", Passwd: "wrong"}
+	params := &AuthParams{RedirectURL: srv.URL + "/eportal/index.jsp?wlanuserip=10.1.2.3"}
+	_, err := ruijieLogin(context.Background(), config, params)
+	if err == nil || !strings.Contains(err.Error(), "密码错误") {
+		t.Errorf("ruijieLogin() error = %v, want 密码错误", err)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// 规则动作
+const (
+	ActionNeedAuth      = "need_auth"
+	ActionAuthenticated = "authenticated"
+	ActionOffCampus     = "off_campus"
+)
+
+// 认证/登出链接的提取来源
+const (
+	ExtractLocation = "location" // 取 Location 头
+	ExtractRedirect = "redirect" // 从页面脚本中提取重定向URL
+	ExtractPage     = "page"     // 取当前页面地址
+)
+
+// DetectRule 门户检测规则，所有已填写的条件都满足时命中
+type DetectRule struct {
+	Name     string            `json:"name"`
+	Status   int               `json:"status"`   // 状态码，0 表示不限
+	Header   map[string]string `json:"header"`   // 响应头名称 -> 正则
+	Body     string            `json:"body"`     // 响应体正则
+	Location string            `json:"location"` // Location 头正则
+	Action   string            `json:"action"`   // need_auth / authenticated / off_campus
+	Extract  string            `json:"extract"`  // location / redirect / 正则（取第一个分组）
+
+	header   map[string]*regexp.Regexp
+	body     *regexp.Regexp
+	location *regexp.Regexp
+	extract  *regexp.Regexp
+}
+
+// 内置规则，对应 GGS 校园网控制器的行为
+var defaultRules = []DetectRule{
+	{
+		Name:   "cloudflare",
+		Status: http.StatusMovedPermanently,
+		Header: map[string]string{"Server": `(?i)cloudflare`},
+		Action: ActionOffCampus,
+	},
+	{
+		Name:    "portal-page",
+		Status:  http.StatusOK,
+		Body:    `portal\.do`,
+		Action:  ActionNeedAuth,
+		Extract: ExtractRedirect,
+	},
+	{
+		Name:     "portal-logout",
+		Status:   http.StatusFound,
+		Location: `portalLogout\.do`,
+		Action:   ActionAuthenticated,
+		Extract:  ExtractLocation,
+	},
+	{
+		Name:     "portal-script",
+		Status:   http.StatusFound,
+		Location: `portalScript\.do`,
+		Action:   ActionNeedAuth,
+		Extract:  ExtractLocation,
+	},
+}
+
+// 解析单条 JSON 格式的检测规则
+func parseDetectRule(value string) (*DetectRule, error) {
+	rule := &DetectRule{}
+	if err := json.Unmarshal([]byte(value), rule); err != nil {
+		return nil, fmt.Errorf("规则不是有效的JSON: %v", err)
+	}
+	if err := rule.compile(); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// 加载门户类型对应的内置规则
+func builtinRules(portalType string) []*DetectRule {
+	defaults := defaultRules
+	switch portalType {
+	case PortalRuijie:
+		defaults = append([]DetectRule{defaultRules[0]}, ruijieRules...)
+	case PortalSrun:
+		defaults = append([]DetectRule{defaultRules[0]}, srunRules...)
+	case PortalForm:
+		defaults = append([]DetectRule{defaultRules[0]}, formRules...)
+	}
+
+	rules := make([]*DetectRule, 0, len(defaults))
+	for _, r := range defaults {
+		rule := r
+		if err := rule.compile(); err != nil {
+			panic(fmt.Sprintf("内置规则 %s 无效: %v", r.Name, err))
+		}
+		rules = append(rules, &rule)
+	}
+	return rules
+}
+
+// 校验并编译规则中的正则
+func (r *DetectRule) compile() error {
+	if r.Name == "" {
+		r.Name = "unnamed"
+	}
+
+	switch r.Action {
+	case ActionNeedAuth, ActionAuthenticated:
+		if r.Extract == "" {
+			r.Extract = ExtractLocation
+		}
+	case ActionOffCampus:
+	case "":
+		return errors.New("规则缺少 action")
+	default:
+		return fmt.Errorf("未知的规则动作: %s", r.Action)
+	}
+
+	var err error
+	r.header = make(map[string]*regexp.Regexp, len(r.Header))
+	for name, pattern := range r.Header {
+		if r.header[name], err = regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("响应头 %s 的正则无效: %v", name, err)
+		}
+	}
+	if r.Body != "" {
+		if r.body, err = regexp.Compile(r.Body); err != nil {
+			return fmt.Errorf("body 正则无效: %v", err)
+		}
+	}
+	if r.Location != "" {
+		if r.location, err = regexp.Compile(r.Location); err != nil {
+			return fmt.Errorf("location 正则无效: %v", err)
+		}
+	}
+	if r.Extract != ExtractLocation && r.Extract != ExtractRedirect && r.Extract != ExtractPage && r.Extract != "" {
+		if r.extract, err = regexp.Compile(r.Extract); err != nil {
+			return fmt.Errorf("extract 正则无效: %v", err)
+		}
+	}
+	return nil
+}
+
+// 判断响应是否命中规则
+func (r *DetectRule) match(resp *http.Response, body string) bool {
+	if r.Status != 0 && r.Status != resp.StatusCode {
+		return false
+	}
+	for name, re := range r.header {
+		if !re.MatchString(resp.Header.Get(name)) {
+			return false
+		}
+	}
+	if r.location != nil && !r.location.MatchString(resp.Header.Get("Location")) {
+		return false
+	}
+	if r.body != nil && !r.body.MatchString(body) {
+		return false
+	}
+	return true
+}
+
+// 按规则提取认证或登出链接
+func (r *DetectRule) extractURL(ctx context.Context, resp *http.Response, body string) string {
+	base := resp.Request.URL
+	switch r.Extract {
+	case ExtractLocation:
+		return resolveRedirect(resp.Header.Get("Location"), base)
+	case ExtractRedirect:
+		return extractRedirectURL(ctx, body, base)
+	case ExtractPage:
+		return base.String()
+	}
+
+	matches := r.extract.FindStringSubmatch(body)
+	switch {
+	case len(matches) > 1:
+		return resolveRedirect(matches[1], base)
+	case len(matches) == 1:
+		return resolveRedirect(matches[0], base)
+	}
+	return ""
+}
+
+// 根据响应判断网络状态
+func classifyResponse(ctx context.Context, result *ProbeResult, config *Config, resp *http.Response, body string) {
+	result.State = ProbeUnknown
+
+	for _, rule := range config.Rules {
+		if !rule.match(resp, body) {
+			continue
+		}
+		logCtx(ctx, DEBUG, "%s 命中检测规则: %s (%s)", result.Probe.URL, rule.Name, rule.Action)
+
+		switch rule.Action {
+		case ActionOffCampus:
+			result.State = ProbeOffCampus
+
+		case ActionAuthenticated:
+			result.State = ProbeLoggedIn
+			result.LogoutURL = rule.extractURL(ctx, resp, body)
+
+		case ActionNeedAuth:
+			redirectURL := rule.extractURL(ctx, resp, body)
+			if redirectURL == "" {
+				logCtx(ctx, ERROR, "命中规则 %s 但未提取到认证URL", rule.Name)
+				result.Err = fmt.Errorf("规则 %s 未提取到认证URL", rule.Name)
+				return
+			}
+
+			params, err := parsePortalParams(ctx, config, redirectURL)
+			if err != nil {
+				result.Err = err
+				return
+			}
+			result.State = ProbeNeedAuth
+			result.Params = params
+		}
+		return
+	}
+
+	logCtx(ctx, DEBUG, "%s 未命中任何检测规则 (状态码: %d)", result.Probe.URL, resp.StatusCode)
+}
+
+// 规则描述，用于日志
+func describeRules(rules []*DetectRule) string {
+	names := make([]string, 0, len(rules))
+	for _, r := range rules {
+		names = append(names, r.Name)
+	}
+	return strings.Join(names, ", ")
+}
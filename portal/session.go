@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 会话相关的默认值
+const (
+	DefaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	CookieFileName   = "cookies.json"
+	RequestTimeout   = 10 * time.Second
+)
+
+// 未显式设置时附加的浏览器请求头
+var browserHeaders = map[string]string{
+	"Accept":          "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+	"Accept-Language": "zh-CN,zh;q=0.9,en;q=0.8",
+}
+
+// session 一次认证流程共用的 HTTP 会话，检测、认证与验证使用同一个 Cookie
+type session struct {
+	name      string // 绑定的网卡，用作日志字段
+	jar       *cookiejar.Jar
+	transport *sharedTransport // 网卡共用的传输层
+	userAgent string
+	path      string        // Cookie 持久化文件，为空表示不持久化
+	remaining time.Duration // Captive Portal API 报告的会话剩余时间，0 表示未知
+
+	mu       sync.Mutex
+	cookies  map[string]storedCookie
+	families map[string]string // 各地址族最近一次的检测状态
+}
+
+// storedCookie 持久化的 Cookie 及其来源地址
+type storedCookie struct {
+	URL    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+type sessionKey struct{}
+
+// 创建会话，使用网卡共用的传输层；配置了状态目录时载入上次保存的 Cookie
+func newSession(config *Config, transport *sharedTransport) *session {
+	jar, _ := cookiejar.New(nil)
+	s := &session{
+		name:      config.Interface,
+		jar:       jar,
+		transport: transport,
+		userAgent: config.UserAgent,
+		cookies:   make(map[string]storedCookie),
+	}
+	if config.StateDir != "" {
+		s.path = filepath.Join(config.StateDir, CookieFileName)
+		if len(config.Interfaces) > 1 {
+			// 多个网卡分别保存，避免不同门户的 Cookie 互相覆盖
+			s.path = filepath.Join(config.StateDir, "cookies-"+config.Interface+".json")
+		}
+		if err := s.load(); err != nil && !os.IsNotExist(err) {
+			s.log(WARN, "载入Cookie失败: %v", err)
+		}
+	}
+	return s
+}
+
+// 将会话附加到 context，同一流程中的请求共用
+func withSession(ctx context.Context, s *session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// 获取当前流程的会话，没有时返回 nil
+func sessionFrom(ctx context.Context) *session {
+	s, _ := ctx.Value(sessionKey{}).(*session)
+	return s
+}
+
+// 当前流程绑定的网卡名称，用于日志
+func linkName(ctx context.Context) string {
+	if s := sessionFrom(ctx); s != nil {
+		return s.name
+	}
+	return ""
+}
+
+// 获取当前流程的 HTTP 客户端，followRedirects 为假时直接返回 3xx 响应。
+// 客户端很轻，连接由网卡共用的传输层复用，整体超时取当前阶段的配置
+func httpClient(ctx context.Context, followRedirects bool) *http.Client {
+	transport := &headerTransport{base: directTransport.forFamily(familyFrom(ctx)), userAgent: DefaultUserAgent}
+	client := &http.Client{Transport: transport, Timeout: timeoutsFrom(ctx).Total}
+	if s := sessionFrom(ctx); s != nil {
+		client.Jar = s
+		transport.base = s.transport.forFamily(familyFrom(ctx))
+		if s.userAgent != "" {
+			transport.userAgent = s.userAgent
+		}
+	}
+	if !followRedirects {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	return client
+}
+
+// 记录地址族的检测状态
+func (s *session) setFamilyState(family, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.families == nil {
+		s.families = make(map[string]string)
+	}
+	s.families[family] = state
+}
+
+// 获取各地址族的检测状态，未分别检测时返回 nil
+func (s *session) familyStates() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.families) == 0 {
+		return nil
+	}
+	states := make(map[string]string, len(s.families))
+	for family, state := range s.families {
+		states[family] = state
+	}
+	return states
+}
+
+// 获取不校验证书、不跟随重定向的客户端，只用于探测拦截 HTTPS 的门户
+func insecureClient(ctx context.Context) *http.Client {
+	client := httpClient(ctx, false)
+	transport := client.Transport.(*headerTransport)
+	if base, ok := transport.base.(*http.Transport); ok {
+		transport.base = insecureTransport(base)
+	}
+	return client
+}
+
+// SetCookies 实现 http.CookieJar，同时记录 Cookie 以便持久化
+func (s *session) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	s.jar.SetCookies(u, cookies)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, c := range cookies {
+		key := u.Hostname() + "|" + c.Domain + "|" + c.Path + "|" + c.Name
+		if c.MaxAge < 0 || !c.Expires.IsZero() && c.Expires.Before(now) {
+			delete(s.cookies, key)
+			continue
+		}
+		stored := *c
+		if c.MaxAge > 0 {
+			stored.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+			stored.MaxAge = 0
+		}
+		s.cookies[key] = storedCookie{URL: u.Scheme + "://" + u.Host + "/", Cookie: &stored}
+	}
+}
+
+// Cookies 实现 http.CookieJar
+func (s *session) Cookies(u *url.URL) []*http.Cookie {
+	return s.jar.Cookies(u)
+}
+
+// 带网卡字段的日志
+func (s *session) log(level int, format string, args ...interface{}) {
+	writeLog(level, s.name, format, args...)
+}
+
+// 结束会话：保存 Cookie，连接留在传输层中供下一次流程复用
+func (s *session) close() {
+	s.save()
+}
+
+// 从状态目录载入 Cookie，跳过已过期的
+func (s *session) load() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var stored []storedCookie
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("解析 %s 失败: %v", s.path, err)
+	}
+	now := time.Now()
+	for _, sc := range stored {
+		u, err := url.Parse(sc.URL)
+		if err != nil || sc.Cookie == nil || !sc.Cookie.Expires.IsZero() && sc.Cookie.Expires.Before(now) {
+			continue
+		}
+		s.SetCookies(u, []*http.Cookie{sc.Cookie})
+	}
+	s.log(DEBUG, "从 %s 载入 %d 个Cookie", s.path, len(s.cookies))
+	return nil
+}
+
+// 将 Cookie 保存到状态目录，未配置状态目录时不做处理
+func (s *session) save() {
+	if s.path == "" {
+		return
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	stored := make([]storedCookie, 0, len(s.cookies))
+	for _, sc := range s.cookies {
+		if sc.Cookie.Expires.IsZero() || sc.Cookie.Expires.After(now) {
+			stored = append(stored, sc)
+		}
+	}
+	s.mu.Unlock()
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		s.log(WARN, "保存Cookie失败: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		s.log(WARN, "创建状态目录失败: %v", err)
+		return
+	}
+	// 先写临时文件再重命名，避免中途退出留下不完整的文件
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		s.log(WARN, "保存Cookie失败: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		s.log(WARN, "保存Cookie失败: %v", err)
+		return
+	}
+	s.log(DEBUG, "已保存 %d 个Cookie到 %s", len(stored), s.path)
+}
+
+// headerTransport 为请求补充 User-Agent 等浏览器请求头，已设置的请求头保持不变；
+// 检测阶段的请求后关闭连接，并按当前阶段限制等待响应头的时间
+type headerTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// 只记录地址与路径，查询参数中可能有账号密码
+	logCtx(req.Context(), DEBUG, "%s %s://%s%s %s", req.Method, req.URL.Scheme, req.URL.Host, req.URL.Path, describeRoute(t.base, req))
+	req = req.Clone(req.Context())
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	for name, value := range browserHeaders {
+		if req.Header.Get(name) == "" {
+			req.Header.Set(name, value)
+		}
+	}
+	// 检测使用新连接，否则认证过期后仍可能经旧连接访问到外网而误判为在线
+	if phaseFrom(req.Context()) == PhaseDetect {
+		req.Close = true
+	}
+	return roundTripWithTimeout(t.base, req)
+}
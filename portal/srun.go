@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 深澜 (SRun) 接口参数
+const (
+	srunChallengePath = "/cgi-bin/get_challenge"
+	srunPortalPath    = "/cgi-bin/srun_portal"
+	srunCallback      = "jQuery112406118340540763985_1556004912581"
+	srunN             = "200"
+	srunType          = "1"
+	srunEncVer        = "srun_bx1"
+	srunDefaultACID   = "1"
+)
+
+// 深澜门户脚本使用的 base64 字母表
+var srunBase64 = base64.NewEncoding("LVoJPiCN2R8G90yg+hmFHuacZ1OWMnrsSTXkYpUq/3dlbfKwv6xztjI7DeBE45QA")
+
+// 深澜控制器的内置检测规则，未认证时跳转到 srun_portal_pc / index_1.html 等页面
+var srunRules = []DetectRule{
+	{
+		Name:    "srun-page",
+		Status:  http.StatusOK,
+		Body:    `srun_portal|/index_\d+\.html`,
+		Action:  ActionNeedAuth,
+		Extract: ExtractRedirect,
+	},
+	{
+		Name:     "srun-redirect",
+		Status:   http.StatusFound,
+		Location: `srun_portal|/index_\d+\.html`,
+		Action:   ActionNeedAuth,
+		Extract:  ExtractLocation,
+	},
+}
+
+// srunResponse get_challenge / srun_portal 的 JSONP 响应
+type srunResponse struct {
+	Challenge string `json:"challenge"`
+	ClientIP  string `json:"client_ip"`
+	Error     string `json:"error"`
+	ErrorMsg  string `json:"error_msg"`
+	Res       string `json:"res"`
+	SucMsg    string `json:"suc_msg"`
+}
+
+// srunInfo 加密前的 info 字段，字段顺序与门户脚本一致
+type srunInfo struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	IP       string `json:"ip"`
+	ACID     string `json:"acid"`
+	EncVer   string `json:"enc_ver"`
+}
+
+// 执行深澜认证，成功时返回登出地址
+func srunLogin(ctx context.Context, config *Config, params *AuthParams) (string, error) {
+	logCtx(ctx, INFO, "开始执行深澜认证")
+
+	u, err := url.Parse(params.RedirectURL)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("无效的门户地址: %s", params.RedirectURL)
+	}
+	base := &url.URL{Scheme: u.Scheme, Host: u.Host}
+	if err := checkCredentialTarget(ctx, config, base.String()); err != nil {
+		return "", err
+	}
+
+	acid := config.SrunACID
+	if acid == "" {
+		acid = params.Query.Get("ac_id")
+	}
+	if acid == "" {
+		acid = srunDefaultACID
+	}
+	ip := params.WlanUserIP
+	if ip == "" {
+		ip = params.Query.Get("ip")
+	}
+
+	// 1. 获取 challenge，未知本机地址时使用门户返回的 client_ip
+	challenge, err := srunCall(ctx, base, srunChallengePath, url.Values{
+		"username": {config.UserID},
+		"ip":       {ip},
+	})
+	if err != nil {
+		return "", fmt.Errorf("获取challenge失败: %v", err)
+	}
+	if challenge.Challenge == "" {
+		return "", fmt.Errorf("获取challenge失败: %s %s", challenge.Error, challenge.ErrorMsg)
+	}
+	if ip == "" {
+		ip = challenge.ClientIP
+	}
+	logCtx(ctx, DEBUG, "深澜challenge: %s, client_ip: %s, ac_id: %s", challenge.Challenge, ip, acid)
+
+	// 2. 计算加密字段并提交
+	token := challenge.Challenge
+	hmd5 := srunHMACMD5(config.Passwd, token)
+	info, err := srunEncodeInfo(srunInfo{
+		Username: config.UserID,
+		Password: config.Passwd,
+		IP:       ip,
+		ACID:     acid,
+		EncVer:   srunEncVer,
+	}, token)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"action":       {"login"},
+		"username":     {config.UserID},
+		"password":     {"{MD5}" + hmd5},
+		"ac_id":        {acid},
+		"ip":           {ip},
+		"chksum":       {srunChecksum(token, config.UserID, hmd5, acid, ip, info)},
+		"info":         {info},
+		"n":            {srunN},
+		"type":         {srunType},
+		"os":           {"Linux"},
+		"name":         {"Linux"},
+		"double_stack": {"0"},
+	}
+	reply, err := srunCall(ctx, base, srunPortalPath, form)
+	if err != nil {
+		return "", err
+	}
+	logCtx(ctx, INFO, "深澜认证响应: error=%s, res=%s, %s%s", reply.Error, reply.Res, reply.SucMsg, reply.ErrorMsg)
+
+	if reply.Error != "ok" {
+		return "", fmt.Errorf("深澜认证失败: %s %s", reply.Error, reply.ErrorMsg)
+	}
+
+	logout := *base
+	logout.Path = srunPortalPath
+	logout.RawQuery = url.Values{
+		"action":   {"logout"},
+		"username": {config.UserID},
+		"ip":       {ip},
+		"ac_id":    {acid},
+	}.Encode()
+	return logout.String(), nil
+}
+
+// 以 JSONP 方式调用深澜接口
+func srunCall(ctx context.Context, base *url.URL, path string, query url.Values) (*srunResponse, error) {
+	query.Set("callback", srunCallback)
+	query.Set("_", strconv.FormatInt(time.Now().UnixMilli(), 10))
+	endpoint := *base
+	endpoint.Path = path
+	endpoint.RawQuery = query.Encode()
+	logCtx(ctx, DEBUG, "发送深澜请求到: %s%s", base, path)
+
+	client := httpClient(ctx, true)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		logCtx(ctx, ERROR, "深澜请求失败: %v", err)
+		return nil, fmt.Errorf("深澜请求失败: %v", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logCtx(ctx, ERROR, "关闭响应体失败: %v", err)
+		}
+	}()
+	logCtx(ctx, DEBUG, "收到深澜响应状态码: %d", resp.StatusCode)
+
+	body, err := readBody(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %v", err)
+	}
+	// 去掉 JSONP 回调包装
+	data := strings.TrimSpace(string(body))
+	if start, end := strings.IndexByte(data, '('), strings.LastIndexByte(data, ')'); start >= 0 && end > start {
+		data = data[start+1 : end]
+	}
+	reply := &srunResponse{}
+	if err := json.Unmarshal([]byte(data), reply); err != nil {
+		return nil, fmt.Errorf("解析深澜响应失败: %v (状态码: %d)", err, resp.StatusCode)
+	}
+	return reply, nil
+}
+
+// 以 challenge 为密钥计算密码的 HMAC-MD5
+func srunHMACMD5(passwd, token string) string {
+	mac := hmac.New(md5.New, []byte(token))
+	mac.Write([]byte(passwd))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 计算 chksum: 各字段前加上 challenge 后拼接取 SHA1
+func srunChecksum(token, username, hmd5, acid, ip, info string) string {
+	var sb strings.Builder
+	for _, field := range []string{username, hmd5, acid, ip, srunN, srunType, info} {
+		sb.WriteString(token)
+		sb.WriteString(field)
+	}
+	sum := sha1.Sum([]byte(sb.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// 生成 info 字段: "{SRBX1}" + 自定义 base64(xencode(JSON, challenge))
+func srunEncodeInfo(info srunInfo, token string) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// 与 JSON.stringify 一致，不转义 < > &
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(info); err != nil {
+		return "", fmt.Errorf("生成info失败: %v", err)
+	}
+	data := bytes.TrimRight(buf.Bytes(), "\n")
+	return "{SRBX1}" + srunBase64.EncodeToString(srunXEncode(data, []byte(token))), nil
+}
+
+// 深澜门户脚本中的 xencode (XXTEA 变体)
+func srunXEncode(msg, key []byte) []byte {
+	if len(msg) == 0 {
+		return nil
+	}
+	v := srunToUint32s(msg, true)
+	k := srunToUint32s(key, false)
+	for len(k) < 4 {
+		k = append(k, 0)
+	}
+
+	n := uint32(len(v) - 1)
+	z, y := v[n], v[0]
+	const delta = 0x9E3779B9
+	var d, e, m, p uint32
+	for q := 6 + 52/(n+1); q > 0; q-- {
+		d += delta
+		e = d >> 2 & 3
+		for p = 0; p < n; p++ {
+			y = v[p+1]
+			m = z>>5 ^ y<<2
+			m += (y>>3 ^ z<<4) ^ (d ^ y)
+			m += k[(p&3)^e] ^ z
+			v[p] += m
+			z = v[p]
+		}
+		y = v[0]
+		m = z>>5 ^ y<<2
+		m += (y>>3 ^ z<<4) ^ (d ^ y)
+		m += k[(p&3)^e] ^ z
+		v[n] += m
+		z = v[n]
+	}
+
+	out := make([]byte, 0, len(v)*4)
+	for _, w := range v {
+		out = append(out, byte(w), byte(w>>8), byte(w>>16), byte(w>>24))
+	}
+	return out
+}
+
+// 按小端序每 4 字节组成一个字，withLength 为真时在末尾追加原始长度
+func srunToUint32s(data []byte, withLength bool) []uint32 {
+	words := make([]uint32, (len(data)+3)/4, (len(data)+3)/4+1)
+	for i, b := range data {
+		words[i>>2] |= uint32(b) << (8 * uint(i&3))
+	}
+	if withLength {
+		words = append(words, uint32(len(data)))
+	}
+	return words
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// 以下向量由深澜门户页面脚本中的 xEncode 与 base64 实现（HMAC-MD5、SHA1 为标准算法）在 Node.js 中计算得到
+const (
+	srunTestToken  = "8e7c1f2a9b4d6e3f0a5c7b9d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f"
+	srunTestUser   = "2021001"
+	srunTestPasswd = "p@ss<w>&rd"
+	srunTestIP     = "10.1.2.3"
+	srunTestHMD5   = "c4d1372a51e2cb7af4ba260ecab6f6c9"
+	srunTestInfo   = "{SRBX1}Xvn+wyHTJ+UMZqeBtvs2k+SFotSI6x48cuNokKxWWwDaL/mZ0GWOZalbwhfMbAzNv+7Iznitd+NwHacv3xlXfOtIiy3PDUaD2sg10tVR4i/VBzzp4CTOiYs1eIXtxHsGsqEFqS=="
+	srunTestChksum = "461534620dcc6677445d91b453edfefe29abc42d"
+)
+
+func TestSrunXEncode(t *testing.T) {
+	tests := []struct {
+		msg, key, want string
+	}{
+		{"hello", "key", "f08847cc6fdecf6bda12909b"},
+		{"The quick brown fox jumps over the lazy dog", srunTestToken,
+			"df4093847372d68a456dd4eeabdb5573b150f3e49aa6bb18f33a01a22e6dd5957a2809bf8c543d166ae908a6d275fc73"},
+		{"", "key", ""},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(srunXEncode([]byte(tt.msg), []byte(tt.key))); got != tt.want {
+			t.Errorf("srunXEncode(%q, %q) = %s, want %s", tt.msg, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestSrunBase64(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"a", "Z+=="},
+		{"ab", "Za2="},
+		{"abc", "ZaRk"},
+		{"\x00\xff\x10\x80", "Lg4+SL=="},
+	}
+	for _, tt := range tests {
+		if got := srunBase64.EncodeToString([]byte(tt.in)); got != tt.want {
+			t.Errorf("srunBase64(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSrunHMACMD5(t *testing.T) {
+	if got := srunHMACMD5(srunTestPasswd, srunTestToken); got != srunTestHMD5 {
+		t.Errorf("srunHMACMD5() = %s, want %s", got, srunTestHMD5)
+	}
+}
+
+func TestSrunEncodeInfo(t *testing.T) {
+	info, err := srunEncodeInfo(srunInfo{
+		Username: srunTestUser,
+		Password: srunTestPasswd,
+		IP:       srunTestIP,
+		ACID:     "1",
+		EncVer:   srunEncVer,
+	}, srunTestToken)
+	if err != nil {
+		t.Fatalf("srunEncodeInfo() error: %v", err)
+	}
+	if info != srunTestInfo {
+		t.Errorf("srunEncodeInfo() = %s, want %s", info, srunTestInfo)
+	}
+}
+
+func TestSrunChecksum(t *testing.T) {
+	// 字段顺序: username, hmd5, ac_id, ip, n, type, info
+	if got := srunChecksum(srunTestToken, srunTestUser, srunTestHMD5, "1", srunTestIP, srunTestInfo); got != srunTestChksum {
+		t.Errorf("srunChecksum() = %s, want %s", got, srunTestChksum)
+	}
+}
+
+// fakeSrun 模拟深澜的 get_challenge 与 srun_portal 接口
+type fakeSrun struct {
+	passwd string
+	login  url.Values // 收到的登录请求
+}
+
+func (f *fakeSrun) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	reply := `{"error":"ok","res":"ok","suc_msg":"login_ok"}`
+	switch r.URL.Path {
+	case srunChallengePath:
+		reply = fmt.Sprintf(`{"challenge":"%s","client_ip":"%s","error":"ok","res":"ok"}`, srunTestToken, srunTestIP)
+	case srunPortalPath:
+		f.login = q
+		hmd5 := srunHMACMD5(f.passwd, srunTestToken)
+		var sb strings.Builder
+		for _, field := range []string{q.Get("username"), hmd5, q.Get("ac_id"), q.Get("ip"), q.Get("n"), q.Get("type"), q.Get("info")} {
+			sb.WriteString(srunTestToken + field)
+		}
+		sum := sha1.Sum([]byte(sb.String()))
+		switch {
+		case q.Get("password") != "{MD5}"+hmd5:
+			reply = `{"error":"login_error","error_msg":"E2901: (Third party 1)bind_user2: ldap_bind error"}`
+		case q.Get("chksum") != hex.EncodeToString(sum[:]):
+			reply = `{"error":"sign_error","error_msg":"chksum"}`
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprintf(w, "%s(%s)", q.Get("callback"), reply)
+}
+
+func TestSrunLogin(t *testing.T) {
+	fake := &fakeSrun{passwd: srunTestPasswd}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	// 重定向地址中没有 IP，使用 get_challenge 返回的 client_ip
+	redirect := srv.URL + "/srun_portal_pc?ac_id=1&theme=pro"
+	u, _ := url.Parse(redirect)
+	config := &Config{UserID: srunTestUser, Passwd: srunTestPasswd}
+	params := &AuthParams{RedirectURL: redirect, Query: u.Query()}
+
+	logout, err := srunLogin(context.Background(), config, params)
+	if err != nil {
+		t.Fatalf("srunLogin() error: %v", err)
+	}
+	for key, want := range map[string]string{
+		"username": srunTestUser,
+		"password": "{MD5}" + srunTestHMD5,
+		"ip":       srunTestIP,
+		"ac_id":    "1",
+		"info":     srunTestInfo,
+		"chksum":   srunTestChksum,
+	} {
+		if got := fake.login.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	wantLogout := srv.URL + srunPortalPath + "?ac_id=1&action=logout&ip=10.1.2.3&username=2021001"
+	if logout != wantLogout {
+		t.Errorf("logout = %q, want %q", logout, wantLogout)
+	}
+
+	// 密码错误时返回门户的错误信息
+	fake.passwd = "other"
+	if _, err := srunLogin(context.Background(), config, params); err == nil || !strings.Contains(err.Error(), "login_error") {
+		t.Errorf("srunLogin() error = %v, want login_error", err)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 请求所属的阶段，各阶段可分别配置超时
+const (
+	PhaseDetect = "detect"
+	PhaseAuth   = "auth"
+	PhaseVerify = "verify"
+	PhaseLogout = "logout"
+)
+
+// 传输层相关的默认值
+const (
+	MaxBodySize     = 1 << 20 // 读取响应体的最大长度
+	IdleConnTimeout = 90 * time.Second
+)
+
+// PhaseTimeouts 一个阶段中每个请求的超时
+type PhaseTimeouts struct {
+	Dial   time.Duration // 解析域名并建立连接
+	TLS    time.Duration // TLS 握手
+	Header time.Duration // 取得连接后发送请求并等待响应头，不含建立连接与 TLS 握手
+	Total  time.Duration // 整个请求，包括重定向与读取响应体
+}
+
+// 未配置时各阶段使用的超时
+var defaultTimeouts = PhaseTimeouts{
+	Dial:   5 * time.Second,
+	TLS:    5 * time.Second,
+	Header: RequestTimeout,
+	Total:  RequestTimeout,
+}
+
+type phaseKey struct{}
+
+// phaseContext 附加到 context 的阶段及其超时
+type phaseContext struct {
+	name     string
+	timeouts PhaseTimeouts
+}
+
+// 解析阶段超时配置，格式: dial:5s tls:5s header:10s total:10s，未写的项使用默认值
+func parseTimeouts(value string) (PhaseTimeouts, error) {
+	timeouts := defaultTimeouts
+	for _, field := range strings.Fields(value) {
+		name, text, ok := strings.Cut(field, ":")
+		d, err := time.ParseDuration(text)
+		if !ok || err != nil || d <= 0 {
+			return timeouts, fmt.Errorf("无效的超时: %s", field)
+		}
+		switch strings.ToLower(name) {
+		case "dial":
+			timeouts.Dial = d
+		case "tls":
+			timeouts.TLS = d
+		case "header":
+			timeouts.Header = d
+		case "total":
+			timeouts.Total = d
+		default:
+			return timeouts, fmt.Errorf("未知的超时项: %s", name)
+		}
+	}
+	return timeouts, nil
+}
+
+// 将阶段附加到 context，其中的请求使用该阶段配置的超时
+func withPhase(ctx context.Context, config *Config, phase string) context.Context {
+	timeouts, ok := config.Timeouts[phase]
+	if !ok {
+		timeouts = defaultTimeouts
+	}
+	return context.WithValue(ctx, phaseKey{}, phaseContext{name: phase, timeouts: timeouts})
+}
+
+// 获取当前请求所属的阶段，未指定时返回空
+func phaseFrom(ctx context.Context) string {
+	p, _ := ctx.Value(phaseKey{}).(phaseContext)
+	return p.name
+}
+
+// 获取当前阶段的超时，未指定阶段时使用默认值
+func timeoutsFrom(ctx context.Context) PhaseTimeouts {
+	if p, ok := ctx.Value(phaseKey{}).(phaseContext); ok {
+		return p.timeouts
+	}
+	return defaultTimeouts
+}
+
+// sharedTransport 守护进程共用的传输层，按地址族各有一个连接池
+type sharedTransport struct {
+	base *http.Transport
+
+	mu       sync.Mutex
+	families map[string]*http.Transport
+
+	stale atomic.Bool // 绑定网卡失败，需要重建
+}
+
+// 创建共用的传输层，dial 为空时使用系统默认的拨号方式
+func newSharedTransport(dial func(ctx context.Context, network, addr string) (net.Conn, error)) *sharedTransport {
+	if dial == nil {
+		dial = (&net.Dialer{KeepAlive: 30 * time.Second}).DialContext
+	}
+	transport := &http.Transport{
+		Proxy:                 contextProxy,
+		TLSHandshakeTimeout:   defaultTimeouts.TLS, // 经代理的 HTTPS 请求由传输层自行握手
+		MaxIdleConns:          16,
+		MaxIdleConnsPerHost:   2,
+		IdleConnTimeout:       IdleConnTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+	setDialers(transport, dial)
+	return &sharedTransport{base: transport}
+}
+
+// 获取地址族对应的传输层，未限定地址族时使用共用的连接池
+func (t *sharedTransport) forFamily(family string) *http.Transport {
+	if family == "" {
+		return t.base
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if transport, ok := t.families[family]; ok {
+		return transport
+	}
+	if t.families == nil {
+		t.families = make(map[string]*http.Transport)
+	}
+	transport := familyTransport(t.base, family)
+	t.families[family] = transport
+	return transport
+}
+
+// 关闭所有空闲连接
+func (t *sharedTransport) closeIdle() {
+	t.base.CloseIdleConnections()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, transport := range t.families {
+		transport.CloseIdleConnections()
+	}
+}
+
+// dialError 建立连接或 TLS 握手时的错误，用于区分连接在何时被重置
+type dialError struct{ err error }
+
+func (e *dialError) Error() string { return e.err.Error() }
+func (e *dialError) Unwrap() error { return e.err }
+
+// Timeout 保留底层错误的超时标记，url.Error 等直接断言该方法
+func (e *dialError) Timeout() bool {
+	t, ok := e.err.(interface{ Timeout() bool })
+	return ok && t.Timeout()
+}
+
+// 设置拨号函数：建立连接与 TLS 握手分别使用当前阶段的超时
+func setDialers(transport *http.Transport, dial func(ctx context.Context, network, addr string) (net.Conn, error)) {
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeoutsFrom(ctx).Dial)
+		defer cancel()
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, &dialError{err: err}
+		}
+		return conn, nil
+	}
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := transport.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		config := &tls.Config{}
+		if transport.TLSClientConfig != nil {
+			config = transport.TLSClientConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = host
+		}
+		tlsConn := tls.Client(conn, config)
+		handshakeCtx, cancel := context.WithTimeout(ctx, timeoutsFrom(ctx).TLS)
+		defer cancel()
+		if err := tlsConn.HandshakeContext(handshakeCtx); err != nil {
+			conn.Close()
+			if ctx.Err() == nil && handshakeCtx.Err() != nil {
+				return nil, fmt.Errorf("TLS握手超时: %v", err)
+			}
+			return nil, &dialError{err: err}
+		}
+		return tlsConn, nil
+	}
+}
+
+// 创建不校验证书、不保持连接的传输层，只用于识别拦截 HTTPS 的门户，不能用于发送账号密码
+func insecureTransport(base *http.Transport) *http.Transport {
+	transport := base.Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	transport.DisableKeepAlives = true
+	setDialers(transport, base.DialContext)
+	return transport
+}
+
+// 按请求的 context 选择代理，未指定时直连
+func contextProxy(req *http.Request) (*url.URL, error) {
+	proxy := proxyFunc(proxyFrom(req.Context()))
+	if proxy == nil {
+		return nil, nil
+	}
+	return proxy(req)
+}
+
+// 发送请求，取得连接后超过当前阶段的等待响应头时间时取消；
+// 建立连接与 TLS 握手由拨号函数按各自的超时限制，不计入等待响应头的时间
+func roundTripWithTimeout(base http.RoundTripper, req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	var (
+		mu       sync.Mutex
+		timer    *time.Timer
+		stopped  bool
+		timedOut atomic.Bool
+	)
+	// 连接失效重试时会再次取得连接，重新计时
+	trace := &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			if stopped {
+				return
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(timeoutsFrom(ctx).Header, func() {
+				timedOut.Store(true)
+				cancel()
+			})
+		},
+	}
+	resp, err := base.RoundTrip(req.WithContext(httptrace.WithClientTrace(ctx, trace)))
+	mu.Lock()
+	stopped = true
+	if timer != nil {
+		timer.Stop()
+	}
+	mu.Unlock()
+
+	if timedOut.Load() {
+		cancel()
+		if err == nil {
+			resp.Body.Close()
+			err = context.Canceled
+		}
+		return nil, fmt.Errorf("等待响应头超时: %v", err)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// 响应体读取完毕或关闭后才释放 context
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody 关闭响应体时取消请求的 context
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// 读取响应体，超过 MaxBodySize 时返回错误
+func readBody(r io.Reader) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, MaxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxBodySize {
+		return nil, fmt.Errorf("响应体超过 %d 字节", MaxBodySize)
+	}
+	return body, nil
+}
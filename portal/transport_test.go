@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundTripHeaderTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	// 建立连接较慢，但不计入等待响应头的时间
+	transport := newSharedTransport(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		time.Sleep(300 * time.Millisecond)
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	})
+	config := &Config{Timeouts: map[string]PhaseTimeouts{
+		PhaseAuth: {Dial: 2 * time.Second, TLS: 2 * time.Second, Header: 150 * time.Millisecond, Total: 5 * time.Second},
+	}}
+	ctx := withPhase(context.Background(), config, PhaseAuth)
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/fast", nil)
+	resp, err := roundTripWithTimeout(transport.base, req)
+	if err != nil {
+		t.Fatalf("连接较慢时 roundTripWithTimeout() error: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "ok" {
+		t.Errorf("响应体 %q, %v", body, err)
+	}
+
+	// 取得连接后响应头迟迟不来
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/slow", nil)
+	if _, err := roundTripWithTimeout(transport.base, req); err == nil || !strings.Contains(err.Error(), "等待响应头超时") {
+		t.Errorf("roundTripWithTimeout() error = %v, want 等待响应头超时", err)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TrustPolicy 门户身份校验策略，未配置的项不校验
+type TrustPolicy struct {
+	AcNames     []string     // 允许的 wlanacname
+	Hosts       []string     // 允许的门户域名，包含子域名
+	Nets        []*net.IPNet // 允许的门户地址段
+	GatewayMACs []string     // 期望的默认网关 MAC，小写冒号分隔
+}
+
+// 是否配置了门户地址限制
+func (p *TrustPolicy) hasHosts() bool {
+	return len(p.Hosts) > 0 || len(p.Nets) > 0
+}
+
+// 解析 trustHost 配置：地址段、IP 地址或域名
+func (p *TrustPolicy) addHost(value string) error {
+	if _, ipNet, err := net.ParseCIDR(value); err == nil {
+		p.Nets = append(p.Nets, ipNet)
+		return nil
+	}
+	if ip := net.ParseIP(value); ip != nil {
+		bits := 8 * len(ip)
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		p.Nets = append(p.Nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		return nil
+	}
+	host := strings.ToLower(strings.TrimPrefix(value, "."))
+	if host == "" || strings.ContainsAny(host, "/:") {
+		return fmt.Errorf("无效的门户地址: %s", value)
+	}
+	p.Hosts = append(p.Hosts, host)
+	return nil
+}
+
+// 解析 trustGatewayMAC 配置
+func (p *TrustPolicy) addGatewayMAC(value string) error {
+	mac, err := net.ParseMAC(value)
+	if err != nil {
+		return fmt.Errorf("无效的MAC地址: %s", value)
+	}
+	p.GatewayMACs = append(p.GatewayMACs, mac.String())
+	return nil
+}
+
+// 判断主机是否为允许的门户，域名不做解析，避免依赖门户网络的 DNS
+func (p *TrustPolicy) allowsHost(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		for _, ipNet := range p.Nets {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, allowed := range p.Hosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// 认证前校验网络环境：wlanacname、重定向地址与默认网关 MAC
+func checkPortalTrust(ctx context.Context, config *Config, params *AuthParams) error {
+	policy := &config.Trust
+
+	if len(policy.AcNames) > 0 && !slices.Contains(policy.AcNames, params.WlanAcName) {
+		return fmt.Errorf("wlanacname %q 不在允许列表中", params.WlanAcName)
+	}
+
+	if policy.hasHosts() && params.RedirectURL != "" {
+		u, err := url.Parse(params.RedirectURL)
+		if err != nil {
+			return fmt.Errorf("无法解析重定向地址: %v", err)
+		}
+		if !policy.allowsHost(u.Hostname()) {
+			return fmt.Errorf("重定向到的门户 %s 不在允许列表中", u.Hostname())
+		}
+	}
+
+	if len(policy.GatewayMACs) > 0 {
+		gateway, mac, err := defaultGatewayMAC(config.Interface)
+		if err != nil {
+			return fmt.Errorf("无法获取默认网关MAC: %v", err)
+		}
+		if !slices.Contains(policy.GatewayMACs, mac) {
+			return fmt.Errorf("默认网关 %s 的MAC %s 与配置不符", gateway, mac)
+		}
+		logCtx(ctx, DEBUG, "默认网关 %s 的MAC %s 与配置一致", gateway, mac)
+	}
+	return nil
+}
+
+// 发送账号密码前校验目标地址，配置了门户地址限制时只允许发往其中的主机
+func checkCredentialTarget(ctx context.Context, config *Config, target string) error {
+	if !config.Trust.hasHosts() {
+		return nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("无法解析认证地址: %v", err)
+	}
+	if !config.Trust.allowsHost(u.Hostname()) {
+		logCtx(ctx, WARN, "认证地址 %s 不在允许的门户列表中，拒绝发送账号密码", u.Hostname())
+		return fmt.Errorf("认证地址 %s 不在允许的门户列表中", u.Hostname())
+	}
+	// 域名由伪造热点的 DNS 解析，只有 HTTPS 证书能确认对方身份
+	if net.ParseIP(u.Hostname()) == nil && !strings.EqualFold(u.Scheme, "https") {
+		logCtx(ctx, WARN, "认证地址 %s 使用 HTTP，无法确认域名的真实性，拒绝发送账号密码；请在 trustHost 中配置门户的 IP 地址或地址段", u.Host)
+		return fmt.Errorf("HTTP 认证地址 %s 的域名无法校验", u.Hostname())
+	}
+	return nil
+}
+
+// 解析 /proc/net/arp 格式的内容，返回指定网卡上网关的 MAC
+func parseARPTable(r io.Reader, gateway net.IP, device string) (string, error) {
+	// IP address  HW type  Flags  HW address  Mask  Device
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[0] != gateway.String() || fields[5] != device {
+			continue
+		}
+		mac, err := net.ParseMAC(fields[3])
+		if err != nil || fields[3] == "00:00:00:00:00:00" {
+			break
+		}
+		return mac.String(), nil
+	}
+	return "", fmt.Errorf("ARP缓存中没有网关 %s 的记录", gateway)
+}
+
+// 解析 /proc/net/route 格式的内容
+func parseRouteTable(r io.Reader, iface string) (net.IP, string, error) {
+	var gateway net.IP
+	var device string
+	bestMetric := -1
+	// Iface  Destination  Gateway  Flags  RefCnt  Use  Metric  Mask ...
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		if iface != "" && fields[0] != iface {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		metric, err := strconv.Atoi(fields[6])
+		if err != nil {
+			continue
+		}
+		if bestMetric < 0 || metric < bestMetric {
+			// 内核将网络字节序的地址按主机字节序的整数输出
+			gateway = make(net.IP, 4)
+			binary.NativeEndian.PutUint32(gateway, binary.BigEndian.Uint32(raw))
+			device, bestMetric = fields[0], metric
+		}
+	}
+	if gateway == nil {
+		return nil, "", errors.New("没有默认路由")
+	}
+	return gateway, device, nil
+}
+
+// GetIpForwardTable 与 GetIpNetTable 返回的表项大小 (Windows iphlpapi)
+const (
+	sizeofIPForwardRow = 56 // MIB_IPFORWARDROW：14 个 DWORD
+	sizeofIPNetRow     = 24 // MIB_IPNETROW
+)
+
+// ARP 表项类型 MIB_IPNET_TYPE_INVALID
+const ipNetTypeInvalid = 2
+
+// 解析 MIB_IPFORWARDTABLE，返回跃点数最小的 IPv4 默认路由的网关与网卡索引，ifIndex 为 0 时不限网卡
+func parseIPForwardTable(data []byte, ifIndex int) (net.IP, int, error) {
+	if len(data) < 4 {
+		return nil, 0, errors.New("路由表数据不完整")
+	}
+	count := int(binary.LittleEndian.Uint32(data))
+	var gateway net.IP
+	var index int
+	var bestMetric uint32
+	for i := 0; i < count; i++ {
+		off := 4 + i*sizeofIPForwardRow
+		if off+sizeofIPForwardRow > len(data) {
+			return nil, 0, errors.New("路由表数据不完整")
+		}
+		row := data[off : off+sizeofIPForwardRow]
+		// 地址字段按网络字节序存放
+		dest, mask, nextHop := row[0:4], row[4:8], row[12:16]
+		rowIndex := int(binary.LittleEndian.Uint32(row[16:20]))
+		metric := binary.LittleEndian.Uint32(row[36:40])
+		if !net.IP(dest).Equal(net.IPv4zero) || !net.IP(mask).Equal(net.IPv4zero) || net.IP(nextHop).Equal(net.IPv4zero) {
+			continue
+		}
+		if ifIndex != 0 && rowIndex != ifIndex {
+			continue
+		}
+		if gateway == nil || metric < bestMetric {
+			gateway = net.IPv4(nextHop[0], nextHop[1], nextHop[2], nextHop[3]).To4()
+			index, bestMetric = rowIndex, metric
+		}
+	}
+	if gateway == nil {
+		return nil, 0, errors.New("没有默认路由")
+	}
+	return gateway, index, nil
+}
+
+// 解析 MIB_IPNETTABLE，返回指定网卡上网关的 MAC
+func parseIPNetTable(data []byte, ifIndex int, gateway net.IP) (string, error) {
+	if len(data) < 4 {
+		return "", errors.New("ARP缓存数据不完整")
+	}
+	count := int(binary.LittleEndian.Uint32(data))
+	for i := 0; i < count; i++ {
+		off := 4 + i*sizeofIPNetRow
+		if off+sizeofIPNetRow > len(data) {
+			return "", errors.New("ARP缓存数据不完整")
+		}
+		row := data[off : off+sizeofIPNetRow]
+		if int(binary.LittleEndian.Uint32(row[0:4])) != ifIndex || !net.IP(row[16:20]).Equal(gateway) {
+			continue
+		}
+		physLen := binary.LittleEndian.Uint32(row[4:8])
+		if physLen != 6 || binary.LittleEndian.Uint32(row[20:24]) == ipNetTypeInvalid {
+			break
+		}
+		mac := net.HardwareAddr(row[8:14])
+		if mac.String() == "00:00:00:00:00:00" {
+			break
+		}
+		return mac.String(), nil
+	}
+	return "", fmt.Errorf("ARP缓存中没有网关 %s 的记录", gateway)
+}
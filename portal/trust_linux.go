@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// 读取默认网关与其 MAC 地址的文件
+const (
+	procRouteFile = "/proc/net/route"
+	procARPFile   = "/proc/net/arp"
+)
+
+// 当前平台可以读取默认网关的 MAC
+const gatewayMACSupported = true
+
+// 从路由表与 ARP 缓存获取默认网关及其 MAC，指定网卡时只取该网卡的默认路由
+func defaultGatewayMAC(iface string) (string, string, error) {
+	gateway, device, err := defaultGateway(iface)
+	if err != nil {
+		return "", "", err
+	}
+
+	file, err := os.Open(procARPFile)
+	if err != nil {
+		return "", "", fmt.Errorf("无法读取ARP缓存: %v", err)
+	}
+	defer file.Close()
+
+	mac, err := parseARPTable(file, gateway, device)
+	if err != nil {
+		return "", "", err
+	}
+	return gateway.String(), mac, nil
+}
+
+// 从 /proc/net/route 读取跃点数最小的 IPv4 默认路由
+func defaultGateway(iface string) (net.IP, string, error) {
+	file, err := os.Open(procRouteFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("无法读取路由表: %v", err)
+	}
+	defer file.Close()
+	return parseRouteTable(file, iface)
+}
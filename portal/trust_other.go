@@ -0,0 +1,21 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// 当前平台不能读取默认网关的 MAC，配置 trustGatewayMAC 时启动失败
+const gatewayMACSupported = false
+
+var errGatewayUnsupported = errors.New("当前平台不支持读取默认网关")
+
+func defaultGatewayMAC(iface string) (string, string, error) {
+	return "", "", errGatewayUnsupported
+}
+
+func defaultGateway(iface string) (net.IP, string, error) {
+	return nil, "", errGatewayUnsupported
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+// 以下内容取自 Linux 主机的 /proc/net/route 与 /proc/net/arp
+const (
+	procRouteSample = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT                                                       
+eth0	00000000	010200C0	0003	0	0	0	00000000	0	0	0                                                                               
+eth0	000200C0	00000000	0001	0	0	0	00FFFFFF	0	0	0                                                                               
+`
+	procARPSample = `IP address       HW type     Flags       HW address            Mask     Device
+192.0.2.1        0x1         0x2         02:fc:00:00:00:05     *        eth0
+`
+
+	// 有线与无线同时连接，NetworkManager 为无线设置较大的跃点数
+	procRouteDualSample = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT                                                       
+wlp2s0	00000000	0100140A	0003	0	0	600	00000000	0	0	0                                                                               
+enp3s0	00000000	FE01A8C0	0003	0	0	100	00000000	0	0	0                                                                               
+enp3s0	0001A8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0                                                                               
+wlp2s0	0000140A	00000000	0001	0	0	600	0000FFFF	0	0	0                                                                               
+`
+	procARPDualSample = `IP address       HW type     Flags       HW address            Mask     Device
+10.20.0.1        0x1         0x2         58:69:6c:1a:2b:3c     *        wlp2s0
+192.168.1.254    0x1         0x0         00:00:00:00:00:00     *        enp3s0
+`
+)
+
+func TestParseRouteTable(t *testing.T) {
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("样本取自小端主机")
+	}
+	tests := []struct {
+		name    string
+		content string
+		iface   string
+		gateway string
+		device  string
+	}{
+		{"单网卡", procRouteSample, "", "192.0.2.1", "eth0"},
+		{"取跃点数最小的默认路由", procRouteDualSample, "", "192.168.1.254", "enp3s0"},
+		{"指定网卡", procRouteDualSample, "wlp2s0", "10.20.0.1", "wlp2s0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway, device, err := parseRouteTable(strings.NewReader(tt.content), tt.iface)
+			if err != nil {
+				t.Fatalf("parseRouteTable() error: %v", err)
+			}
+			if gateway.String() != tt.gateway || device != tt.device {
+				t.Errorf("parseRouteTable() = %s, %s, want %s, %s", gateway, device, tt.gateway, tt.device)
+			}
+		})
+	}
+
+	if _, _, err := parseRouteTable(strings.NewReader(procRouteSample), "eth1"); err == nil {
+		t.Error("网卡没有默认路由时 parseRouteTable() 没有返回错误")
+	}
+	header := strings.SplitN(procRouteSample, "\n", 2)[0]
+	if _, _, err := parseRouteTable(strings.NewReader(header), ""); err == nil {
+		t.Error("没有默认路由时 parseRouteTable() 没有返回错误")
+	}
+}
+
+func TestParseARPTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		gateway string
+		device  string
+		want    string // 空表示应返回错误
+	}{
+		{"单网卡", procARPSample, "192.0.2.1", "eth0", "02:fc:00:00:00:05"},
+		{"指定网卡", procARPDualSample, "10.20.0.1", "wlp2s0", "58:69:6c:1a:2b:3c"},
+		{"网卡不符", procARPDualSample, "10.20.0.1", "enp3s0", ""},
+		{"未完成解析的条目", procARPDualSample, "192.168.1.254", "enp3s0", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mac, err := parseARPTable(strings.NewReader(tt.content), net.ParseIP(tt.gateway), tt.device)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("parseARPTable() = %s, want error", mac)
+				}
+				return
+			}
+			if err != nil || mac != tt.want {
+				t.Errorf("parseARPTable() = %s, %v, want %s", mac, err, tt.want)
+			}
+		})
+	}
+}
+
+// 按 MIB_IPFORWARDROW 布局构造路由表项
+func ipForwardRow(dest, mask, nextHop string, ifIndex, metric uint32) []byte {
+	row := make([]byte, sizeofIPForwardRow)
+	copy(row[0:4], net.ParseIP(dest).To4())
+	copy(row[4:8], net.ParseIP(mask).To4())
+	copy(row[12:16], net.ParseIP(nextHop).To4())
+	binary.LittleEndian.PutUint32(row[16:20], ifIndex)
+	binary.LittleEndian.PutUint32(row[20:24], 4) // MIB_IPROUTE_TYPE_INDIRECT
+	binary.LittleEndian.PutUint32(row[36:40], metric)
+	return row
+}
+
+// 按 MIB_IPNETROW 布局构造 ARP 表项
+func ipNetRow(ifIndex uint32, mac, addr string, typ uint32) []byte {
+	row := make([]byte, sizeofIPNetRow)
+	binary.LittleEndian.PutUint32(row[0:4], ifIndex)
+	hw, _ := net.ParseMAC(mac)
+	binary.LittleEndian.PutUint32(row[4:8], uint32(len(hw)))
+	copy(row[8:16], hw)
+	copy(row[16:20], net.ParseIP(addr).To4())
+	binary.LittleEndian.PutUint32(row[20:24], typ)
+	return row
+}
+
+// 拼接表头的表项数与各表项
+func ipHelperTableData(rows ...[]byte) []byte {
+	data := binary.LittleEndian.AppendUint32(nil, uint32(len(rows)))
+	for _, row := range rows {
+		data = append(data, row...)
+	}
+	return data
+}
+
+func TestParseIPForwardTable(t *testing.T) {
+	// 有线（索引 7）与无线（索引 12）同时连接
+	data := ipHelperTableData(
+		ipForwardRow("0.0.0.0", "0.0.0.0", "10.20.0.1", 12, 35),
+		ipForwardRow("0.0.0.0", "0.0.0.0", "192.168.1.254", 7, 25),
+		ipForwardRow("10.20.0.0", "255.255.0.0", "10.20.3.4", 12, 291),
+		ipForwardRow("127.0.0.0", "255.0.0.0", "127.0.0.1", 1, 331),
+	)
+	tests := []struct {
+		name    string
+		ifIndex int
+		gateway string
+		index   int
+	}{
+		{"取跃点数最小的默认路由", 0, "192.168.1.254", 7},
+		{"指定网卡", 12, "10.20.0.1", 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway, index, err := parseIPForwardTable(data, tt.ifIndex)
+			if err != nil || gateway.String() != tt.gateway || index != tt.index {
+				t.Errorf("parseIPForwardTable() = %s, %d, %v, want %s, %d", gateway, index, err, tt.gateway, tt.index)
+			}
+		})
+	}
+	if _, _, err := parseIPForwardTable(data, 3); err == nil {
+		t.Error("网卡没有默认路由时 parseIPForwardTable() 没有返回错误")
+	}
+	if _, _, err := parseIPForwardTable(data[:len(data)-1], 0); err == nil {
+		t.Error("数据不完整时 parseIPForwardTable() 没有返回错误")
+	}
+}
+
+func TestParseIPNetTable(t *testing.T) {
+	data := ipHelperTableData(
+		ipNetRow(12, "58-69-6c-1a-2b-3c", "10.20.0.1", 3),
+		ipNetRow(7, "00-00-00-00-00-00", "192.168.1.254", 2),
+		ipNetRow(7, "01-00-5e-00-00-fb", "224.0.0.251", 4),
+	)
+	tests := []struct {
+		name    string
+		ifIndex int
+		gateway string
+		want    string // 空表示应返回错误
+	}{
+		{"动态表项", 12, "10.20.0.1", "58:69:6c:1a:2b:3c"},
+		{"网卡不符", 7, "10.20.0.1", ""},
+		{"无效表项", 7, "192.168.1.254", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mac, err := parseIPNetTable(data, tt.ifIndex, net.ParseIP(tt.gateway))
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("parseIPNetTable() = %s, want error", mac)
+				}
+				return
+			}
+			if err != nil || mac != tt.want {
+				t.Errorf("parseIPNetTable() = %s, %v, want %s", mac, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// 当前平台可以读取默认网关的 MAC
+const gatewayMACSupported = true
+
+var (
+	iphlpapi              = syscall.NewLazyDLL("iphlpapi.dll")
+	procGetIpForwardTable = iphlpapi.NewProc("GetIpForwardTable")
+	procGetIpNetTable     = iphlpapi.NewProc("GetIpNetTable")
+)
+
+// 从 IP 路由表与 ARP 缓存获取默认网关及其 MAC，指定网卡时只取该网卡的默认路由
+func defaultGatewayMAC(iface string) (string, string, error) {
+	gateway, index, err := defaultGatewayIndex(iface)
+	if err != nil {
+		return "", "", err
+	}
+	data, err := ipHelperTable(procGetIpNetTable)
+	if err != nil {
+		return "", "", fmt.Errorf("无法读取ARP缓存: %v", err)
+	}
+	mac, err := parseIPNetTable(data, index, gateway)
+	if err != nil {
+		return "", "", err
+	}
+	return gateway.String(), mac, nil
+}
+
+// 读取跃点数最小的 IPv4 默认路由，返回网关与网卡名称
+func defaultGateway(iface string) (net.IP, string, error) {
+	gateway, index, err := defaultGatewayIndex(iface)
+	if err != nil {
+		return nil, "", err
+	}
+	ifi, err := net.InterfaceByIndex(index)
+	if err != nil {
+		return nil, "", err
+	}
+	return gateway, ifi.Name, nil
+}
+
+// 读取默认路由的网关与网卡索引
+func defaultGatewayIndex(iface string) (net.IP, int, error) {
+	var index int
+	if iface != "" {
+		ifi, err := net.InterfaceByName(iface)
+		if err != nil {
+			return nil, 0, err
+		}
+		index = ifi.Index
+	}
+	data, err := ipHelperTable(procGetIpForwardTable)
+	if err != nil {
+		return nil, 0, fmt.Errorf("无法读取路由表: %v", err)
+	}
+	return parseIPForwardTable(data, index)
+}
+
+// 调用 GetIpForwardTable、GetIpNetTable 等按缓冲区大小返回表的函数，表在两次调用之间变大时重试
+func ipHelperTable(proc *syscall.LazyProc) ([]byte, error) {
+	if err := proc.Find(); err != nil {
+		return nil, err
+	}
+	size := uint32(4096)
+	for i := 0; i < 4; i++ {
+		buf := make([]byte, size)
+		r, _, _ := proc.Call(uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)), 0)
+		switch syscall.Errno(r) {
+		case 0:
+			return buf[:size], nil
+		case syscall.ERROR_INSUFFICIENT_BUFFER:
+			continue
+		default:
+			return nil, syscall.Errno(r)
+		}
+	}
+	return nil, errors.New("表在读取期间持续变化")
+}
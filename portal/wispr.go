@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WISPr 1.0 响应码
+const (
+	WISPrLoginSucceeded = 50
+	WISPrLoginFailed    = 100
+	WISPrAuthPending    = 201
+)
+
+// 认证结果轮询的最大次数
+const wisprMaxPolls = 5
+
+// wisprGatewayParam 网关下发的 <WISPAccessGatewayParam> 文档
+type wisprGatewayParam struct {
+	XMLName                 xml.Name      `xml:"WISPAccessGatewayParam"`
+	Redirect                *wisprMessage `xml:"Redirect"`
+	Proxy                   *wisprMessage `xml:"Proxy"`
+	AuthenticationReply     *wisprMessage `xml:"AuthenticationReply"`
+	AuthenticationPollReply *wisprMessage `xml:"AuthenticationPollReply"`
+	LogoffReply             *wisprMessage `xml:"LogoffReply"`
+}
+
+// wisprMessage WISPr 消息，不同消息只使用其中部分字段
+type wisprMessage struct {
+	MessageType     int    `xml:"MessageType"`
+	ResponseCode    int    `xml:"ResponseCode"`
+	AccessProcedure string `xml:"AccessProcedure"`
+	AccessLocation  string `xml:"AccessLocation"`
+	LocationName    string `xml:"LocationName"`
+	LoginURL        string `xml:"LoginURL"`
+	AbortLoginURL   string `xml:"AbortLoginURL"`
+	NextURL         string `xml:"NextURL"`
+	LoginResultsURL string `xml:"LoginResultsURL"`
+	LogoffURL       string `xml:"LogoffURL"`
+	ReplyMessage    string `xml:"ReplyMessage"`
+	Delay           int    `xml:"Delay"`
+}
+
+// 获取文档中携带的消息
+func (p *wisprGatewayParam) message() *wisprMessage {
+	for _, m := range []*wisprMessage{p.Redirect, p.Proxy, p.AuthenticationReply, p.AuthenticationPollReply, p.LogoffReply} {
+		if m != nil {
+			return m
+		}
+	}
+	return nil
+}
+
+// 从页面中提取 WISPr XML，通常位于 HTML 注释内
+func parseWISPr(ctx context.Context, body string) *wisprGatewayParam {
+	start := strings.Index(body, "<WISPAccessGatewayParam")
+	if start < 0 {
+		return nil
+	}
+	end := strings.Index(body[start:], "</WISPAccessGatewayParam>")
+	if end < 0 {
+		return nil
+	}
+	doc := body[start : start+end+len("</WISPAccessGatewayParam>")]
+
+	param := &wisprGatewayParam{}
+	if err := xml.Unmarshal([]byte(doc), param); err != nil {
+		logCtx(ctx, WARN, "解析WISPr XML失败: %v", err)
+		return nil
+	}
+	if param.message() == nil {
+		return nil
+	}
+	return param
+}
+
+// 探测响应中带有 WISPr 重定向时判定为需要认证
+func classifyWISPr(ctx context.Context, result *ProbeResult, resp *http.Response, body string) bool {
+	param := parseWISPr(ctx, body)
+	if param == nil || (param.Redirect == nil && param.Proxy == nil) {
+		return false
+	}
+
+	msg := param.message()
+	logCtx(ctx, DEBUG, "%s 检测到WISPr消息 (MessageType %d, ResponseCode %d, 位置: %s)",
+		result.Probe.URL, msg.MessageType, msg.ResponseCode, msg.LocationName)
+	result.State = ProbeNeedAuth
+	result.Params = &AuthParams{
+		RedirectURL: resp.Request.URL.String(),
+		WISPr:       param,
+		PortalType:  PortalWISPr,
+	}
+	return true
+}
+
+// 按 WISPr 1.0 流程提交账号密码，成功时返回登出链接
+func wisprLogin(ctx context.Context, config *Config, param *wisprGatewayParam, originURL string) (string, error) {
+	logCtx(ctx, INFO, "开始执行WISPr认证")
+	if config.WISPrUserID == "" || config.WISPrPasswd == "" {
+		return "", errors.New("未配置 wisprUserid 与 wisprPasswd，不向WISPr热点提交校园网账号")
+	}
+
+	client := httpClient(ctx, false)
+
+	// 代理通知需要先访问 NextURL 获取重定向消息
+	redirect := param.Redirect
+	if redirect == nil && param.Proxy != nil {
+		if param.Proxy.NextURL == "" {
+			return "", errors.New("WISPr代理通知中没有NextURL")
+		}
+		logCtx(ctx, DEBUG, "WISPr代理通知，访问NextURL: %s", param.Proxy.NextURL)
+		next, err := wisprFetch(ctx, client, http.MethodGet, param.Proxy.NextURL, nil)
+		if err != nil {
+			return "", err
+		}
+		redirect = next.Redirect
+	}
+	if redirect == nil || redirect.LoginURL == "" {
+		return "", errors.New("WISPr消息中没有LoginURL")
+	}
+	if !strings.HasPrefix(strings.ToLower(redirect.LoginURL), "https://") {
+		logCtx(ctx, WARN, "WISPr LoginURL 不是 HTTPS，账号密码将以明文传输: %s", redirect.LoginURL)
+	}
+
+	form := url.Values{
+		"UserName":          {config.WISPrUserID},
+		"Password":          {config.WISPrPasswd},
+		"button":            {"Login"},
+		"FNAME":             {"0"},
+		"OriginatingServer": {originURL},
+	}
+	logCtx(ctx, DEBUG, "提交WISPr认证到: %s", redirect.LoginURL)
+	reply, err := wisprFetch(ctx, client, http.MethodPost, redirect.LoginURL, form)
+	if err != nil {
+		return "", err
+	}
+
+	// 认证挂起时按网关给出的间隔轮询结果
+	for i := 0; i < wisprMaxPolls; i++ {
+		msg := reply.message()
+		if msg == nil || msg.ResponseCode != WISPrAuthPending {
+			break
+		}
+		if msg.LoginResultsURL == "" {
+			return "", errors.New("WISPr认证挂起但没有LoginResultsURL")
+		}
+		delay := time.Duration(msg.Delay) * time.Second
+		if delay <= 0 {
+			delay = 2 * time.Second
+		}
+		logCtx(ctx, DEBUG, "WISPr认证挂起，%v 后查询结果", delay)
+		if err := sleepContext(ctx, delay); err != nil {
+			return "", err
+		}
+		if reply, err = wisprFetch(ctx, client, http.MethodGet, msg.LoginResultsURL, nil); err != nil {
+			return "", err
+		}
+	}
+
+	msg := reply.message()
+	if msg == nil {
+		return "", errors.New("WISPr认证响应中没有可识别的消息")
+	}
+	logCtx(ctx, INFO, "WISPr认证响应: MessageType %d, ResponseCode %d %s", msg.MessageType, msg.ResponseCode, msg.ReplyMessage)
+
+	switch msg.ResponseCode {
+	case WISPrLoginSucceeded:
+		if msg.LogoffURL != "" {
+			logCtx(ctx, INFO, "WISPr认证成功，登出链接: %s", msg.LogoffURL)
+		}
+		return msg.LogoffURL, nil
+	case WISPrLoginFailed:
+		return "", fmt.Errorf("WISPr认证被拒绝: %s", msg.ReplyMessage)
+	case WISPrAuthPending:
+		return "", errors.New("WISPr认证结果查询超时")
+	default:
+		return "", fmt.Errorf("WISPr认证失败 (ResponseCode %d): %s", msg.ResponseCode, msg.ReplyMessage)
+	}
+}
+
+// 发送请求并解析响应中的 WISPr XML
+func wisprFetch(ctx context.Context, client *http.Client, method, target string, form url.Values) (*wisprGatewayParam, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, fmt.Errorf("创建WISPr请求失败: %v", err)
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("WISPr请求失败: %v", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logCtx(ctx, ERROR, "关闭响应体失败: %v", err)
+		}
+	}()
+
+	data, err := readBody(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取WISPr响应失败: %v", err)
+	}
+	param := parseWISPr(ctx, string(data))
+	if param == nil {
+		return nil, fmt.Errorf("WISPr响应中没有XML消息 (状态码: %d)", resp.StatusCode)
+	}
+	return param, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// 按 WISPr 1.0 规范与 ChinaNet、CMCC 热点返回的格式整理的消息
+const (
+	// ChinaNet 热点的重定向页面，消息位于 HTML 注释中
+	wisprChinaNetPage = `<html>
+<head><title>ChinaNet</title></head>
+<body>
+<!--<?xml version="1.0" encoding="UTF-8"?>
+<WISPAccessGatewayParam xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:noNamespaceSchemaLocation="http://www.acmewisp.com/WISPAccessGatewayParam.xsd">
+<Redirect>
+<AccessProcedure>1.0</AccessProcedure>
+<AccessLocation>CDATA[[isocc=cn,cc=86,ac=20,network=ChinaNet,]]</AccessLocation>
+<LocationName>CDATA[[ChinaNet,GD_GZ_0001]]</LocationName>
+<LoginURL>https://wlan.ct10000.com/wispr/login.do?wlanacname=0020.0200.200.00&amp;wlanuserip=100.64.12.34</LoginURL>
+<AbortLoginURL>https://wlan.ct10000.com/wispr/abort.do</AbortLoginURL>
+<MessageType>100</MessageType>
+<ResponseCode>0</ResponseCode>
+</Redirect>
+</WISPAccessGatewayParam>
+-->
+<script>location.href="https://wlan.ct10000.com/";</script>
+</body>
+</html>`
+
+	// CMCC 热点的代理通知，需要先访问 NextURL
+	wisprCMCCProxy = `<?xml version="1.0" encoding="UTF-8"?>
+<WISPAccessGatewayParam xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:noNamespaceSchemaLocation="http://www.acmewisp.com/WISPAccessGatewayParam.xsd">
+<Proxy>
+<MessageType>110</MessageType>
+<ResponseCode>200</ResponseCode>
+<NextURL>http://221.176.1.140/wlan/index.php?wlanacname=1037.0010.100.00</NextURL>
+</Proxy>
+</WISPAccessGatewayParam>`
+
+	// 认证成功的响应
+	wisprAuthReply = `<!--
+<WISPAccessGatewayParam xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:noNamespaceSchemaLocation="http://www.acmewisp.com/WISPAccessGatewayParam.xsd">
+<AuthenticationReply>
+<MessageType>120</MessageType>
+<ResponseCode>50</ResponseCode>
+<ReplyMessage>Authentication Success</ReplyMessage>
+<LogoffURL>https://wlan.ct10000.com/wispr/logoff.do?id=abc</LogoffURL>
+</AuthenticationReply>
+</WISPAccessGatewayParam>
+-->`
+)
+
+func TestParseWISPr(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		messageType  int
+		responseCode int
+		loginURL     string
+		nextURL      string
+		logoffURL    string
+	}{
+		{
+			name:        "ChinaNet 注释中的重定向",
+			body:        wisprChinaNetPage,
+			messageType: 100,
+			loginURL:    "https://wlan.ct10000.com/wispr/login.do?wlanacname=0020.0200.200.00&wlanuserip=100.64.12.34",
+		},
+		{
+			name:         "CMCC 代理通知",
+			body:         wisprCMCCProxy,
+			messageType:  110,
+			responseCode: 200,
+			nextURL:      "http://221.176.1.140/wlan/index.php?wlanacname=1037.0010.100.00",
+		},
+		{
+			name:         "认证成功",
+			body:         wisprAuthReply,
+			messageType:  120,
+			responseCode: WISPrLoginSucceeded,
+			logoffURL:    "https://wlan.ct10000.com/wispr/logoff.do?id=abc",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			param := parseWISPr(context.Background(), tt.body)
+			if param == nil {
+				t.Fatal("parseWISPr() = nil")
+			}
+			msg := param.message()
+			if msg.MessageType != tt.messageType || msg.ResponseCode != tt.responseCode {
+				t.Errorf("MessageType %d, ResponseCode %d, want %d, %d", msg.MessageType, msg.ResponseCode, tt.messageType, tt.responseCode)
+			}
+			if msg.LoginURL != tt.loginURL || msg.NextURL != tt.nextURL || msg.LogoffURL != tt.logoffURL {
+				t.Errorf("LoginURL %q, NextURL %q, LogoffURL %q", msg.LoginURL, msg.NextURL, msg.LogoffURL)
+			}
+		})
+	}
+
+	for _, body := range []string{
+		"<html><body>普通页面</body></html>",
+		"<WISPAccessGatewayParam><Redirect><LoginURL>http://x/</LoginURL>",
+		"<WISPAccessGatewayParam></WISPAccessGatewayParam>",
+	} {
+		if param := parseWISPr(context.Background(), body); param != nil {
+			t.Errorf("parseWISPr(%q) = %+v, want nil", body, param)
+		}
+	}
+}
+
+func TestClassifyWISPr(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"重定向需要认证", wisprChinaNetPage, true},
+		{"代理通知需要认证", wisprCMCCProxy, true},
+		{"认证响应不是探测结果", wisprAuthReply, false},
+		{"没有消息", "<html></html>", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "http://www.msftconnecttest.com/connecttest.txt", nil)
+			resp := &http.Response{StatusCode: http.StatusOK, Request: req}
+			result := &ProbeResult{Probe: Probe{URL: req.URL.String()}}
+			if got := classifyWISPr(context.Background(), result, resp, tt.body); got != tt.want {
+				t.Fatalf("classifyWISPr() = %v, want %v", got, tt.want)
+			}
+			if !tt.want {
+				return
+			}
+			if result.State != ProbeNeedAuth || result.Params == nil || result.Params.WISPr == nil {
+				t.Fatalf("result = %+v", result)
+			}
+			if result.Params.PortalType != PortalWISPr || result.Params.RedirectURL != req.URL.String() {
+				t.Errorf("PortalType %q, RedirectURL %q", result.Params.PortalType, result.Params.RedirectURL)
+			}
+		})
+	}
+}
+
+func TestWISPrLogin(t *testing.T) {
+	var form url.Values
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/next":
+			fmt.Fprintf(w, `<WISPAccessGatewayParam><Redirect><MessageType>100</MessageType><ResponseCode>0</ResponseCode><LoginURL>%s/login</LoginURL></Redirect></WISPAccessGatewayParam>`, srv.URL)
+		case "/login":
+			r.ParseForm()
+			form = r.PostForm
+			fmt.Fprint(w, strings.Replace(wisprAuthReply, "https://wlan.ct10000.com/wispr", srv.URL, 1))
+		}
+	}))
+	defer srv.Close()
+
+	// 代理通知先访问 NextURL，再向其中的 LoginURL 提交 WISPr 专用账号
+	param := parseWISPr(context.Background(), strings.Replace(wisprCMCCProxy, "http://221.176.1.140/wlan/index.php?wlanacname=1037.0010.100.00", srv.URL+"/next", 1))
+	config := &Config{UserID: "campus", Passwd: "campus", WISPrUserID: "13800000000@cmcc", WISPrPasswd: "wispr"}
+	logoff, err := wisprLogin(context.Background(), config, param, "http://www.msftconnecttest.com/")
+	if err != nil {
+		t.Fatalf("wisprLogin() error: %v", err)
+	}
+	if form.Get("UserName") != "13800000000@cmcc" || form.Get("Password") != "wispr" {
+		t.Errorf("提交的账号 %q/%q", form.Get("UserName"), form.Get("Password"))
+	}
+	if logoff != srv.URL+"/logoff.do?id=abc" {
+		t.Errorf("logoff = %q", logoff)
+	}
+
+	// 未配置 WISPr 专用账号时不提交校园网账号
+	form = nil
+	config.WISPrUserID, config.WISPrPasswd = "", ""
+	if _, err := wisprLogin(context.Background(), config, param, ""); err == nil || form != nil {
+		t.Errorf("wisprLogin() error = %v, form = %v", err, form)
+	}
+}